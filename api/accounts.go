@@ -0,0 +1,105 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"cosmossdk.io/x/circuit/types"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// The payload used to grant permissions to an account via the authorize api
+type AuthorizePayload struct {
+	// permission level to grant, one of the `types.Permissions_Level` names, ex: "LEVEL_SOME_MSGS"
+	Level string
+	// module request urls the account is limited to, only used with "LEVEL_SOME_MSGS"
+	LimitTypeUrls []string
+}
+
+// A response returned from all account management calls
+type PermissionsResponse struct {
+	// response message, if no errors set to "ok" otherwise includes the error
+	Message string
+	// the account which had its permissions updated
+	Address string
+	// the permission level that was granted
+	Level         string
+	LimitTypeUrls []string
+	// transaction hash of the transaction which was sent, if the call failed
+	// this is an empty string
+	TxHash string
+}
+
+// Grants the account given by the `address` url parameter the permissions
+// described by the AuthorizePayload in the request body.
+func (api *API) AuthorizeAccount(w http.ResponseWriter, r *http.Request) {
+	if api.breakerClient == nil {
+		http.Error(w, "no initialized breaker client", http.StatusInternalServerError)
+		return
+	}
+	address := chi.URLParam(r, "address")
+	if _, err := api.breakerClient.Client.DecodeBech32AccAddr(address); err != nil {
+		http.Error(w, fmt.Sprintf("invalid address %s", err), http.StatusBadRequest)
+		return
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var payload AuthorizePayload
+	if err = json.Unmarshal(data, &payload); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, ok := types.Permissions_Level_value[payload.Level]; !ok {
+		http.Error(w, fmt.Sprintf("unsupported permission level %s", payload.Level), http.StatusBadRequest)
+		return
+	}
+
+	response := PermissionsResponse{
+		Address:       address,
+		Level:         payload.Level,
+		LimitTypeUrls: payload.LimitTypeUrls,
+	}
+	if tx, err := api.breakerClient.Authorize(r.Context(), address, payload.Level, payload.LimitTypeUrls); err != nil {
+		response.Message = fmt.Sprintf("failed to authorize account %s", err)
+		api.logger.Error("failed to authorize account", zap.String("address", address), zap.Error(err))
+	} else {
+		response.Message = "ok"
+		response.TxHash = tx
+		api.logger.Info("authorized account", zap.String("address", address), zap.String("level", payload.Level), zap.Any("limit.type.urls", payload.LimitTypeUrls))
+	}
+	api.serveJSON(w, r, &response)
+}
+
+// Revokes all permissions held by the account given by the `address` url parameter.
+func (api *API) RevokeAccount(w http.ResponseWriter, r *http.Request) {
+	if api.breakerClient == nil {
+		http.Error(w, "no initialized breaker client", http.StatusInternalServerError)
+		return
+	}
+	address := chi.URLParam(r, "address")
+	if _, err := api.breakerClient.Client.DecodeBech32AccAddr(address); err != nil {
+		http.Error(w, fmt.Sprintf("invalid address %s", err), http.StatusBadRequest)
+		return
+	}
+
+	response := PermissionsResponse{
+		Address: address,
+		Level:   types.Permissions_LEVEL_NONE_UNSPECIFIED.String(),
+	}
+	if tx, err := api.breakerClient.Revoke(r.Context(), address); err != nil {
+		response.Message = fmt.Sprintf("failed to revoke account %s", err)
+		api.logger.Error("failed to revoke account", zap.String("address", address), zap.Error(err))
+	} else {
+		response.Message = "ok"
+		response.TxHash = tx
+		api.logger.Info("revoked account", zap.String("address", address))
+	}
+	api.serveJSON(w, r, &response)
+}
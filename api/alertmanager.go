@@ -0,0 +1,249 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// label or annotation containing a comma separated list of module request urls
+	ALERT_CIRCUIT_URLS = "circuit_urls"
+	// label or annotation containing a comma separated list of circuit group names
+	ALERT_CIRCUIT_GROUPS = "circuit_groups"
+	// status of an alert which is firing, causing the circuit to be tripped
+	ALERT_STATUS_FIRING = "firing"
+	// status of an alert which has been resolved, optionally causing the circuit to be reset
+	ALERT_STATUS_RESOLVED = "resolved"
+)
+
+// The webhook payload sent by Prometheus Alertmanager, as documented at
+// https://prometheus.io/docs/alerting/latest/configuration/#webhook_config
+type AlertmanagerPayload struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	TruncatedAlerts   int               `json:"truncatedAlerts"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Alerts            []Alert           `json:"alerts"`
+}
+
+// A single alert contained within an AlertmanagerPayload
+type Alert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+// Returns the value of `key` from the alert annotations, falling back to the alert labels
+func (a *Alert) lookup(key string) string {
+	if v := a.Annotations[key]; v != "" {
+		return v
+	}
+	return a.Labels[key]
+}
+
+// Returns the module request urls the alert applies to
+func (a *Alert) Urls() []string {
+	return splitList(a.lookup(ALERT_CIRCUIT_URLS))
+}
+
+// Returns the names of the circuit groups the alert applies to
+func (a *Alert) Groups() []string {
+	return splitList(a.lookup(ALERT_CIRCUIT_GROUPS))
+}
+
+// Splits a comma, space or newline separated list
+func splitList(list string) []string {
+	return strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n'
+	})
+}
+
+// Returns the reason for the alert, used in the same manner as PayloadV1.Message
+func (a *Alert) Summary() string {
+	if v := a.Annotations["summary"]; v != "" {
+		return v
+	}
+	if v := a.Annotations["description"]; v != "" {
+		return v
+	}
+	return a.Labels["alertname"]
+}
+
+// The result of processing a single alert
+type AlertResult struct {
+	Fingerprint string
+	AlertName   string
+	Status      string
+	// set when no operation was applied for the alert, describing why
+	Skipped string
+	// set when the alert could not be processed, such as when the circuit states could not be queried.
+	// other alerts of the notification are still processed
+	Error string
+	// set when an operation was applied for the alert
+	Response *Response
+}
+
+// A response returned from the alertmanager webhook, containing one result per alert
+type AlertmanagerResponse struct {
+	Results []AlertResult
+}
+
+// Handles webhook calls sent by Prometheus Alertmanager. The module request urls of each alert are read
+// from the `circuit_urls` annotation or label as a comma separated list. Firing alerts trip the circuit
+// for their urls, while resolved alerts reset them if `api.alertmanager.reset_on_resolved` is enabled.
+//
+// Alertmanager repeats notifications for alerts which are still firing, so urls which are already disabled
+// are not tripped again, and urls which aren't disabled are not reset.
+func (api *API) HandleAlertmanagerWebhook(w http.ResponseWriter, r *http.Request) {
+	if api.breakerClient == nil {
+		http.Error(w, "no initialized breaker client", http.StatusInternalServerError)
+		return
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var payload AlertmanagerPayload
+	if err = json.Unmarshal(data, &payload); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// operations are recorded in the audit log with the time the notification was received
+	ctx := withAuditIntake(r.Context(), auditIntake{RequestedAt: time.Now().UTC()})
+	// reject the entire request if the caller is missing the scope required by any alert
+	identity := IdentityFromContext(ctx)
+	for _, alert := range payload.Alerts {
+		scope, operation := "", MODE_TRIP
+		if alert.Status == ALERT_STATUS_FIRING {
+			scope = RequiredScope(MODE_TRIP)
+		} else if alert.Status == ALERT_STATUS_RESOLVED && api.alertmanagerResetOnResolved {
+			scope, operation = RequiredScope(MODE_RESET), MODE_RESET
+		}
+		if scope != "" && len(alert.Urls())+len(alert.Groups()) > 0 && !identity.HasScope(scope) {
+			payload := PayloadV1{Message: alert.Summary(), Urls: alert.Urls(), Groups: alert.Groups(), Operation: operation}
+			api.recordIntake(ctx, payload, &Response{Message: fmt.Sprintf("missing required scope %s", scope)}, http.StatusForbidden)
+			forbidScope(w, scope)
+			return
+		}
+	}
+
+	var response AlertmanagerResponse
+	for _, alert := range payload.Alerts {
+		result := AlertResult{
+			Fingerprint: alert.Fingerprint,
+			AlertName:   alert.Labels["alertname"],
+			Status:      alert.Status,
+		}
+		urls := alert.Urls()
+		groups := alert.Groups()
+		api.logger.Info("received alert",
+			zap.String("alert.name", result.AlertName),
+			zap.String("alert.status", alert.Status),
+			zap.String("alert.summary", alert.Summary()),
+			zap.Any("urls", urls),
+			zap.Any("groups", groups),
+		)
+
+		var operation Mode
+		switch {
+		case len(urls) == 0 && len(groups) == 0:
+			result.Skipped = "alert has no circuit urls"
+		case alert.Status == ALERT_STATUS_FIRING:
+			operation = MODE_TRIP
+		case alert.Status == ALERT_STATUS_RESOLVED && api.alertmanagerResetOnResolved:
+			operation = MODE_RESET
+		case alert.Status == ALERT_STATUS_RESOLVED:
+			result.Skipped = "resetting circuits on resolved alerts is disabled"
+		default:
+			result.Skipped = "unsupported alert status"
+		}
+		if result.Skipped == "" {
+			if urls, _, err = api.resolveGroups(urls, groups); err != nil {
+				result.Skipped = err.Error()
+			} else if urls, _, err = api.expandUrls(ctx, urls); err != nil {
+				result.Skipped = err.Error()
+			}
+		}
+		if result.Skipped == "" {
+			if invalid := api.validateUrls(ctx, urls); len(invalid) > 0 {
+				unknown := make([]string, 0, len(invalid))
+				for _, url := range invalid {
+					unknown = append(unknown, url.Url)
+				}
+				result.Skipped = fmt.Sprintf("unknown module request urls %s", strings.Join(unknown, ", "))
+			}
+		}
+		if result.Skipped == "" {
+			urls, err = api.pendingUrls(ctx, operation, urls)
+			if err != nil {
+				api.logger.Error("failed to list disabled commands", zap.String("alert.name", result.AlertName), zap.Error(err))
+				result.Error = fmt.Sprintf("failed to list disabled commands %s", err)
+			} else if len(urls) == 0 {
+				result.Skipped = "circuit is already in the requested state"
+			}
+		}
+		if result.Skipped == "" && result.Error == "" {
+			payload := PayloadV1{
+				Message:   alert.Summary(),
+				Urls:      urls,
+				Operation: operation,
+			}
+			pending, err := api.proposeIfRequired(ctx, payload)
+			if err != nil {
+				api.logger.Error("failed to create proposal", zap.String("alert.name", result.AlertName), zap.Error(err))
+				result.Error = err.Error()
+			} else if pending != nil {
+				result.Response = pending
+			} else {
+				res := api.executePayload(ctx, payload, nil)
+				result.Response = &res
+			}
+		}
+		response.Results = append(response.Results, result)
+	}
+	api.serveJSON(w, r, &response)
+}
+
+// Filters `urls` to those whose circuit is not already in the state resulting from `operation`.
+func (api *API) pendingUrls(ctx context.Context, operation Mode, urls []string) ([]string, error) {
+	res, err := api.breakerClient.ListDisabledCommands(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return filterPending(operation, urls, res.DisabledList), nil
+}
+
+// Filters `urls` to those whose circuit is not already in the state resulting from `operation`, given the `disabled` urls
+func filterPending(operation Mode, urls []string, disabled []string) []string {
+	isDisabled := make(map[string]bool, len(disabled))
+	for _, url := range disabled {
+		isDisabled[url] = true
+	}
+	var pending []string
+	for _, url := range urls {
+		if (operation == MODE_TRIP) != isDisabled[url] {
+			pending = append(pending, url)
+		}
+	}
+	return pending
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlertmanagerPayload(t *testing.T) {
+	raw := `{
+		"version": "4",
+		"groupKey": "{}:{alertname=\"HighOutflow\"}",
+		"status": "firing",
+		"receiver": "breaker",
+		"alerts": [
+			{
+				"status": "firing",
+				"labels": {"alertname": "HighOutflow", "circuit_urls": "/cosmos.bank.v1beta1.MsgSend"},
+				"annotations": {"summary": "bank outflow > 1000"},
+				"startsAt": "2023-07-20T10:00:00Z",
+				"fingerprint": "a1"
+			},
+			{
+				"status": "resolved",
+				"labels": {"alertname": "DexExploit", "circuit_groups": "dex-freeze"},
+				"annotations": {"circuit_urls": "/osmosis.gamm.v1beta1.MsgSwapExactAmountIn, /osmosis.gamm.v1beta1.MsgSwapExactAmountOut"},
+				"fingerprint": "b2"
+			},
+			{
+				"status": "firing",
+				"labels": {"alertname": "NoUrls"},
+				"fingerprint": "c3"
+			}
+		]
+	}`
+	var payload AlertmanagerPayload
+	require.NoError(t, json.Unmarshal([]byte(raw), &payload))
+	require.Equal(t, "firing", payload.Status)
+	require.Len(t, payload.Alerts, 3)
+
+	require.Equal(t, []string{"/cosmos.bank.v1beta1.MsgSend"}, payload.Alerts[0].Urls())
+	require.Equal(t, "bank outflow > 1000", payload.Alerts[0].Summary())
+
+	require.Equal(t, []string{
+		"/osmosis.gamm.v1beta1.MsgSwapExactAmountIn",
+		"/osmosis.gamm.v1beta1.MsgSwapExactAmountOut",
+	}, payload.Alerts[1].Urls())
+	require.Equal(t, []string{"dex-freeze"}, payload.Alerts[1].Groups())
+	// falls back to the alert name when no summary or description is present
+	require.Equal(t, "DexExploit", payload.Alerts[1].Summary())
+
+	require.Empty(t, payload.Alerts[2].Urls())
+	require.Empty(t, payload.Alerts[2].Groups())
+}
@@ -1,13 +1,17 @@
 package api
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/go-chi/jwtauth/v5"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/teamscanworks/breaker/breakerclient"
 	"go.uber.org/zap"
 )
@@ -20,7 +24,47 @@ type API struct {
 	logger        *zap.Logger
 	jwt           *JWT
 	breakerClient *breakerclient.BreakerClient
+	jobs          *jobStore
 	addr          string
+	// verifies hmac signed requests, nil if no hmac senders are configured
+	hmac *hmacVerifier
+	// if true, all webhook calls wait for transactions to be committed
+	waitForCommit bool
+	// if true, transactions are simulated instead of being broadcast
+	dryRun bool
+	// maximum time to wait for a transaction to be committed
+	commitTimeout time.Duration
+	// if true, resolved alertmanager alerts reset the circuit for their urls
+	alertmanagerResetOnResolved bool
+	// records every circuit operation, nil if the audit log is disabled
+	audit *AuditLog
+	// operations which require approval by a second identity, nil if approvals are disabled
+	approvalPolicy *ApprovalPolicy
+	approvals      *approvalStore
+	// automatic resets scheduled when tripping circuits with a ttl
+	resets *resetStore
+	// maintenance windows during which circuits are tripped
+	schedules *scheduleStore
+	// message type urls registered by the chain, used to validate payload urls. nil without a breaker client
+	messages *messageCache
+	// named lists of module request urls which payloads may reference instead of listing urls
+	groups map[string][]string
+	// tracks circuit changes made on chain by something other than the api
+	drift         *driftDetector
+	driftInterval time.Duration
+	// notified of drift, nil if drift notifications are disabled
+	driftNotifier *Notifier
+	// credentials which may be exchanged for short lived jwts
+	apiKeys            []APIKey
+	clientCertificates []ClientCertificate
+	// time in seconds that jwts issued by the api are valid for
+	issuedTokenValidity int64
+	// time in seconds after exchanging a bootstrap credential that its jwt may be refreshed for
+	issuedTokenMaxLifetime int64
+	// when set the api is served over tls using the certificate and key files
+	tlsConfig   *tls.Config
+	tlsCertFile string
+	tlsKeyFile  string
 	// used to block closure until api is shutdown
 	doneCh chan struct{}
 }
@@ -31,9 +75,53 @@ type ApiOpts struct {
 	Password                     string
 	IdentifierField              string
 	TokenValidityDurationSeconds int64
+	// if true, webhook calls wait for transactions to be committed and report the execution result
+	WaitForCommit bool
+	// maximum time in seconds to wait for a transaction to be committed, defaults to 30 seconds
+	CommitTimeoutSeconds int64
+	// if true, resolved alertmanager alerts reset the circuit for their urls
+	AlertmanagerResetOnResolved bool
+	// senders which may authenticate by signing requests with a shared secret instead of using a jwt
+	HMACSenders []HMACSender
+	// maximum age in seconds of hmac signed requests, defaults to 300 seconds
+	HMACToleranceSeconds int64
+	// api keys which may be exchanged for a jwt via `/v1/auth/token`
+	APIKeys []APIKey
+	// client certificates which may be exchanged for a jwt via `/v1/auth/token`, requires TLSClientCAFile
+	ClientCertificates []ClientCertificate
+	// time in seconds that jwts issued via `/v1/auth/token` and `/v1/auth/refresh` are valid for, defaults to 900 seconds
+	IssuedTokenValiditySeconds int64
+	// time in seconds after exchanging a bootstrap credential that the issued jwt may be refreshed for, defaults to 86400 seconds
+	IssuedTokenMaxLifetimeSeconds int64
+	// if set, the api is served over tls using the given PEM encoded certificate and key
+	TLSCertFile string
+	TLSKeyFile  string
+	// PEM encoded certificate authority used to verify client certificates
+	TLSClientCAFile string
+	// path to the audit log recording every circuit operation, disabled if empty
+	AuditLogPath string
+	// if true, audit entries are signed with the breaker client's keyring key
+	AuditSignEntries bool
+	// if set, matching operations require approval by a second identity before being executed
+	Approvals *ApprovalPolicy
+	// path to the file persisting automatic resets scheduled when tripping circuits with a ttl,
+	// if empty scheduled resets are lost when the api is restarted
+	ScheduledResetsPath string
+	// path to the file persisting maintenance windows, if empty maintenance windows are lost when the api is restarted
+	SchedulesPath string
+	// if true, transactions are simulated to report the estimated gas instead of being broadcast
+	DryRun bool
+	// named lists of module request urls, which may be referenced by webhook payloads, alerts and maintenance windows
+	Groups map[string][]string
+	// interval in seconds at which circuits tripped on chain are polled to detect changes not made by the api,
+	// drift detection is disabled if zero
+	DriftIntervalSeconds int64
+	// if set, detected drift is posted as json to this webhook url
+	DriftNotifyURL string
 }
 
-// Prepares the http api server
+// Prepares the http api server. Tokens are verified with `jwt`, or if nil a HS256 JWT
+// using the password and identifier field from `opts`.
 func NewAPI(
 	ctx context.Context,
 	log *zap.Logger,
@@ -43,42 +131,204 @@ func NewAPI(
 ) (*API, error) {
 	ctx, cancel := context.WithCancel(ctx)
 
-	api := API{
-		ctx:    ctx,
-		cancel: cancel,
-		router: chi.NewRouter(),
-		jwt: NewJWT(
+	commitTimeout := time.Second * 30
+	if opts.CommitTimeoutSeconds > 0 {
+		commitTimeout = time.Second * time.Duration(opts.CommitTimeoutSeconds)
+	}
+
+	if jwt == nil {
+		jwt = NewJWT(
 			opts.Password,
 			opts.IdentifierField,
 			opts.TokenValidityDurationSeconds,
-		),
-		addr:          opts.ListenAddress,
-		logger:        log.Named("breaker.api"),
-		breakerClient: bc,
-		doneCh:        make(chan struct{}, 1),
+		)
+	}
+
+	api := API{
+		ctx:                         ctx,
+		cancel:                      cancel,
+		router:                      chi.NewRouter(),
+		jwt:                         jwt,
+		addr:                        opts.ListenAddress,
+		waitForCommit:               opts.WaitForCommit,
+		dryRun:                      opts.DryRun,
+		commitTimeout:               commitTimeout,
+		alertmanagerResetOnResolved: opts.AlertmanagerResetOnResolved,
+		apiKeys:                     opts.APIKeys,
+		clientCertificates:          opts.ClientCertificates,
+		logger:                      log.Named("breaker.api"),
+		breakerClient:               bc,
+		jobs:                        newJobStore(),
+		approvalPolicy:              opts.Approvals,
+		approvals:                   newApprovalStore(),
+		groups:                      opts.Groups,
+		drift:                       newDriftDetector(),
+		driftInterval:               time.Second * time.Duration(opts.DriftIntervalSeconds),
+		doneCh:                      make(chan struct{}, 1),
+	}
+
+	api.issuedTokenValidity = 900
+	if opts.IssuedTokenValiditySeconds > 0 {
+		api.issuedTokenValidity = opts.IssuedTokenValiditySeconds
+	}
+	api.issuedTokenMaxLifetime = 86400
+	if opts.IssuedTokenMaxLifetimeSeconds > 0 {
+		api.issuedTokenMaxLifetime = opts.IssuedTokenMaxLifetimeSeconds
+	}
+	if opts.TLSCertFile != "" {
+		tlsConfig, err := newTLSConfig(opts.TLSClientCAFile)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		api.tlsConfig = tlsConfig
+		api.tlsCertFile = opts.TLSCertFile
+		api.tlsKeyFile = opts.TLSKeyFile
+	} else if len(opts.ClientCertificates) > 0 {
+		cancel()
+		return nil, fmt.Errorf("client certificates require the api to be served over tls")
+	}
+	if !jwt.CanSign() && len(opts.APIKeys)+len(opts.ClientCertificates) > 0 {
+		cancel()
+		return nil, fmt.Errorf("api keys and client certificates require a jwt signing key, and can't be used with oidc")
+	}
+	if jwt.LegacyScopelessTokens() {
+		api.logger.Warn("tokens without a scopes claim are granted full access as legacy_scopeless_tokens is enabled, reissue them with scopes and disable it")
+	}
+	// without an identifier field every jwt identity has an empty name, and can never approve a proposal
+	if opts.Approvals != nil && jwt.identifierField == "" {
+		cancel()
+		return nil, fmt.Errorf("approvals require api.identifier_field to be set, so that jwt identities can be told apart")
+	}
+
+	if opts.AuditLogPath != "" {
+		audit, err := OpenAuditLog(opts.AuditLogPath)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		if opts.AuditSignEntries {
+			if bc == nil {
+				cancel()
+				return nil, fmt.Errorf("signing audit entries requires a breaker client")
+			}
+			audit.SetSigner(bc)
+		}
+		api.audit = audit
+	}
+
+	if err := validateGroups(opts.Groups); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if bc != nil {
+		api.messages = newMessageCache(bc.ListMessages)
+	}
+	if opts.DriftNotifyURL != "" {
+		api.driftNotifier = NewNotifier(opts.DriftNotifyURL)
+	}
+
+	resets, err := openResetStore(opts.ScheduledResetsPath)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	api.resets = resets
+	if opts.ScheduledResetsPath == "" {
+		api.logger.Warn("scheduled resets are not persisted, and will be lost if the api is restarted")
+	}
+	schedules, err := openScheduleStore(opts.SchedulesPath)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	api.schedules = schedules
+	if opts.SchedulesPath == "" {
+		api.logger.Warn("maintenance windows are not persisted, and will be lost if the api is restarted")
+	}
+
+	if len(opts.HMACSenders) > 0 {
+		tolerance := time.Second * 300
+		if opts.HMACToleranceSeconds > 0 {
+			tolerance = time.Second * time.Duration(opts.HMACToleranceSeconds)
+		}
+		api.hmac = newHMACVerifier(opts.HMACSenders, tolerance)
 	}
 
 	// initialize router
 	api.router.Use(middleware.RequestID)
 	api.router.Use(NewLoggerMiddleware(api.logger))
+	// the metrics are only updated by drift detection, and are unauthenticated, so are only served when it is enabled
+	if api.driftInterval > 0 {
+		api.router.Handle("/metrics", promhttp.HandlerFor(api.drift.metrics.registry, promhttp.HandlerOpts{}))
+	}
 	api.router.Route("/v1", func(r chi.Router) {
 		r.Group(func(r chi.Router) {
 			// authenticated urls
-			r.Use(jwtauth.Verifier(api.jwt.tokenAuth))
-			r.Use(api.jwt.Authenticator)
+			r.Use(api.Authenticator)
 			r.Post("/webhook", api.HandleWebookV1)
+			r.Post("/webhook/alertmanager", api.HandleAlertmanagerWebhook)
+			// tokens can only be issued with a signing key, which isn't available when using oidc
+			if jwt.CanSign() {
+				r.Post("/auth/refresh", api.RefreshToken)
+			}
+			r.With(RequireScope(SCOPE_CIRCUIT_READ)).Get("/jobs/{id}", api.GetJob)
+			r.With(RequireScope(SCOPE_CIRCUIT_READ)).Get("/audit", api.GetAudit)
+			r.With(RequireScope(SCOPE_CIRCUIT_READ)).Get("/resets", api.ListScheduledResets)
+			r.With(RequireScope(SCOPE_CIRCUIT_READ)).Get("/groups", api.ListGroups)
+			r.With(RequireScope(SCOPE_CIRCUIT_READ)).Get("/drift", api.ListDriftEvents)
+			// the scopes required depend on the circuits tripped and reset by the plan
+			r.Put("/circuits/desired", api.SetDesiredState)
+			// cancelling a scheduled reset keeps circuits tripped, so requires the trip scope
+			r.With(RequireScope(SCOPE_CIRCUIT_TRIP)).Delete("/resets/{id}", api.CancelScheduledReset)
+			r.Route("/schedules", func(r chi.Router) {
+				r.With(RequireScope(SCOPE_CIRCUIT_READ)).Get("/", api.ListSchedules)
+				r.With(RequireScope(SCOPE_CIRCUIT_READ)).Get("/{id}", api.GetSchedule)
+				// maintenance windows both trip and reset circuits
+				r.Group(func(r chi.Router) {
+					r.Use(RequireScope(SCOPE_CIRCUIT_TRIP), RequireScope(SCOPE_CIRCUIT_RESET))
+					r.Post("/", api.CreateSchedule)
+					r.Put("/{id}", api.UpdateSchedule)
+					r.Delete("/{id}", api.DeleteSchedule)
+				})
+			})
+			r.Route("/approvals", func(r chi.Router) {
+				r.With(RequireScope(SCOPE_CIRCUIT_READ)).Get("/", api.ListApprovals)
+				r.With(RequireScope(SCOPE_CIRCUIT_READ)).Get("/{id}", api.GetApproval)
+				// the scope required to approve depends on the operation of the proposal
+				r.Post("/{id}/approve", api.ApproveProposal)
+			})
+			r.Route("/accounts/{address}", func(r chi.Router) {
+				// account management urls, requiring admin access
+				r.Use(RequireScope(SCOPE_ACCOUNTS_ADMIN))
+				r.Post("/authorize", api.AuthorizeAccount)
+				r.Post("/revoke", api.RevokeAccount)
+			})
 		})
 		r.Group(func(r chi.Router) {
 			// unauthenticated urls
+			if jwt.CanSign() {
+				r.Post("/auth/token", api.IssueToken)
+			}
 			r.Route("/status", func(r chi.Router) {
+				r.Get("/account/{address}", api.GetAccount)
 				r.Route("/list", func(r chi.Router) {
 					r.Get("/disabledCommands", api.ListDisabledCommands)
+					r.Get("/messages", api.ListMessages)
 					r.Get("/accounts", api.ListAccounts)
 				})
 			})
 		})
 	})
 
+	go api.runJobs()
+	go api.runScheduledResets()
+	go api.runSchedules()
+	if bc != nil && api.driftInterval > 0 {
+		go api.runDriftDetection(api.driftInterval)
+	}
+
 	return &api, nil
 }
 
@@ -99,18 +349,28 @@ func ConfigBreakerClient(
 func (api *API) Close() {
 	api.cancel()
 	<-api.doneCh
+	if api.audit != nil {
+		if err := api.audit.Close(); err != nil {
+			api.logger.Error("failed to close audit log", zap.Error(err))
+		}
+	}
 }
 
 // Blocking call that starts a http server exposing the api.
 func (api *API) Serve() error {
 	server := http.Server{
-		Addr:    api.addr,
-		Handler: api.router,
+		Addr:      api.addr,
+		Handler:   api.router,
+		TLSConfig: api.tlsConfig,
 	}
 	errCh := make(chan error, 1)
-	api.logger.Info("starting api")
+	api.logger.Info("starting api", zap.Bool("tls", api.tlsConfig != nil), zap.Bool("dry.run", api.dryRun))
 	go func() {
-		errCh <- server.ListenAndServe()
+		if api.tlsConfig != nil {
+			errCh <- server.ListenAndServeTLS(api.tlsCertFile, api.tlsKeyFile)
+		} else {
+			errCh <- server.ListenAndServe()
+		}
 	}()
 	for {
 		select {
@@ -123,3 +383,27 @@ func (api *API) Serve() error {
 		}
 	}
 }
+
+// Serializes `response` as json, and writes it to the client.
+func (api *API) serveJSON(w http.ResponseWriter, r *http.Request, response interface{}) {
+	rBytes, err := json.Marshal(response)
+	if err != nil {
+		api.logger.Error("failed to serialize response", zap.Error(err))
+		http.Error(w, "failed to serialize response", http.StatusInternalServerError)
+		return
+	}
+	http.ServeContent(w, r, "", time.Now(), bytes.NewReader(rBytes))
+}
+
+// Serializes `response` as json, and writes it to the client with the given status code.
+func (api *API) writeJSON(w http.ResponseWriter, statusCode int, response interface{}) {
+	rBytes, err := json.Marshal(response)
+	if err != nil {
+		api.logger.Error("failed to serialize response", zap.Error(err))
+		http.Error(w, "failed to serialize response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(rBytes)
+}
@@ -2,134 +2,748 @@ package api
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"cosmossdk.io/x/circuit/types"
+	"github.com/cosmos/cosmos-sdk/types/query"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/teamscanworks/breaker/breakerclient"
+)
+
+var (
+	// returned when looking up an account which holds no permissions with the circuit breaker module
+	ErrNoPermissions = breakerclient.ErrNoPermissions
+	// returned by the status api helper when the server responds with 404 Not Found
+	errNotFound = errors.New("not found")
 )
 
 type APIClient struct {
 	hc  *http.Client
 	url string
 	jwt string
+	// content type requested from the status api
+	accept string
+	// when set, requests are signed with the hmac secret instead of including the jwt
+	hmacSender string
+	hmacSecret string
+	// when set, the jwt is acquired and renewed by exchanging the api key
+	apiKey string
+	// when true, the jwt is acquired and renewed by exchanging the client certificate
+	certificateBootstrap bool
+	// when greater than zero, the jwt is renewed once it expires within this duration
+	refreshMargin time.Duration
+	// guards jwt renewal, shared between copies of the client
+	tokenMu *sync.Mutex
+}
+
+// Used to customize the APIClient returned by NewAPIClient
+type APIClientOption func(*APIClient)
+
+// Sets the content type requested from the status api, either CONTENT_TYPE_JSON (the default) or CONTENT_TYPE_PROTOBUF
+func WithAcceptType(contentType string) APIClientOption {
+	return func(ac *APIClient) {
+		ac.accept = contentType
+	}
+}
+
+// Authenticates requests by signing them with the shared secret of a hmac sender configured
+// in `api.hmac_senders`, instead of including the jwt
+func WithHMAC(sender string, secret string) APIClientOption {
+	return func(ac *APIClient) {
+		ac.hmacSender = sender
+		ac.hmacSecret = secret
+	}
+}
+
+// Sets the http client used to send requests
+func WithHTTPClient(hc *http.Client) APIClientOption {
+	return func(ac *APIClient) {
+		ac.hc = hc
+	}
+}
+
+// Acquires a jwt by exchanging the api key with `/v1/auth/token`, renewing it before it expires.
+// The jwt given to NewAPIClient may be left empty.
+func WithAPIKey(apiKey string) APIClientOption {
+	return func(ac *APIClient) {
+		ac.apiKey = apiKey
+		if ac.refreshMargin == 0 {
+			ac.refreshMargin = time.Minute
+		}
+	}
+}
+
+// Presents `cert` when connecting to an api served over tls, verifying the server against `rootCAs`
+// or the system roots if nil. A jwt is acquired by exchanging the certificate with `/v1/auth/token`,
+// and renewed before it expires. The jwt given to NewAPIClient may be left empty.
+func WithClientCertificate(cert tls.Certificate, rootCAs *x509.CertPool) APIClientOption {
+	return func(ac *APIClient) {
+		ac.hc = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					Certificates: []tls.Certificate{cert},
+					RootCAs:      rootCAs,
+					MinVersion:   tls.VersionTLS12,
+				},
+			},
+		}
+		ac.certificateBootstrap = true
+		if ac.refreshMargin == 0 {
+			ac.refreshMargin = time.Minute
+		}
+	}
+}
+
+// Renews the jwt via `/v1/auth/refresh` once it expires within `margin`, which requires the jwt to have been issued
+// via `/v1/auth/token`. When combined with WithAPIKey or WithClientCertificate, the bootstrap credential is exchanged
+// for a new jwt instead.
+func WithAutoRefresh(margin time.Duration) APIClientOption {
+	return func(ac *APIClient) {
+		ac.refreshMargin = margin
+	}
 }
 
 // Returns a new client for usage with the breaker api.
-// Requires providing a valid JWT that has been issued, which can be done via the cli
+// Requires providing a valid JWT that has been issued, which can be done via the cli, or
+// a bootstrap credential given with WithAPIKey or WithClientCertificate.
 //
 // NOTE: JWT is not required for the `/status` api calls
-//
-// TODO: add a way of acquiring/renewing JWT via api
-func NewAPIClient(url string, jwt string) APIClient {
-	return APIClient{
-		hc:  http.DefaultClient,
-		url: url,
-		jwt: jwt,
+func NewAPIClient(url string, jwt string, opts ...APIClientOption) APIClient {
+	ac := APIClient{
+		hc:      http.DefaultClient,
+		url:     url,
+		jwt:     jwt,
+		accept:  CONTENT_TYPE_JSON,
+		tokenMu: &sync.Mutex{},
 	}
+	for _, opt := range opts {
+		opt(&ac)
+	}
+	return ac
 }
 
 // Returns all commands which have had a circuit tripped
 func (ac *APIClient) DisabledCommands() (*types.DisabledListResponse, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/v1/status/list/disabledCommands", ac.url), &bytes.Buffer{})
+	var resp types.DisabledListResponse
+	if err := ac.getStatus("/v1/status/list/disabledCommands", &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Returns the message type urls registered by the chain, which are accepted as module request urls
+func (ac *APIClient) Messages() (*MessagesResponse, error) {
+	data, err := ac.send("GET", "/v1/status/list/messages", nil, "", false)
 	if err != nil {
-		return nil, fmt.Errorf("failed to construct http request %s", err)
+		return nil, err
 	}
-	res, err := ac.hc.Do(req)
+	var resp MessagesResponse
+	if err = json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("failed to deserialize http response body %s", err)
+	}
+	return &resp, nil
+}
+
+// Returns the permissions granted to `address`. If the account holds no permissions
+// ErrNoPermissions is returned.
+func (ac *APIClient) Account(address string) (*types.AccountResponse, error) {
+	var resp types.AccountResponse
+	if err := ac.getStatus(fmt.Sprintf("/v1/status/account/%s", address), &resp); errors.Is(err, errNotFound) {
+		return nil, ErrNoPermissions
+	} else if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Returns the first page of accounts that have been granted some form of permission with the circuit breaker module,
+// using the module's default page size. Use AccountsPage or AccountsIterator to retrieve subsequent pages.
+func (ac *APIClient) Accounts() (*types.AccountsResponse, error) {
+	return ac.AccountsPage(nil)
+}
+
+// Returns a page of accounts that have been granted some form of permission with the circuit breaker module.
+// The `Pagination.NextKey` of the response may be used as `page.Key` to request the following page.
+func (ac *APIClient) AccountsPage(page *query.PageRequest) (*types.AccountsResponse, error) {
+	path := "/v1/status/list/accounts"
+	if page != nil {
+		values := url.Values{}
+		if page.Limit > 0 {
+			values.Set("limit", strconv.FormatUint(page.Limit, 10))
+		}
+		if page.Offset > 0 {
+			values.Set("offset", strconv.FormatUint(page.Offset, 10))
+		}
+		if len(page.Key) > 0 {
+			values.Set("key", base64.StdEncoding.EncodeToString(page.Key))
+		}
+		if page.CountTotal {
+			values.Set("count_total", "true")
+		}
+		if page.Reverse {
+			values.Set("reverse", "true")
+		}
+		if len(values) > 0 {
+			path = fmt.Sprintf("%s?%s", path, values.Encode())
+		}
+	}
+	var resp types.AccountsResponse
+	if err := ac.getStatus(path, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Returns an iterator that walks every page of accounts, requesting `limit` accounts per page.
+// If `limit` is 0 the module's default page size is used.
+//
+// ```
+// it := apiClient.AccountsIterator(100)
+//
+//	for it.Next() {
+//		for _, acct := range it.Page().Accounts {
+//			// ...
+//		}
+//	}
+//
+//	if err := it.Err(); err != nil {
+//		panic(err)
+//	}
+//
+// ```
+func (ac *APIClient) AccountsIterator(limit uint64) *AccountsIterator {
+	return &AccountsIterator{ac: ac, limit: limit}
+}
+
+// Iterates over all pages of the accounts listing, see APIClient.AccountsIterator
+type AccountsIterator struct {
+	ac    *APIClient
+	limit uint64
+	// key of the next page to request, nil when requesting the first page
+	nextKey []byte
+	page    *types.AccountsResponse
+	done    bool
+	err     error
+}
+
+// Requests the next page, returning false once all pages have been consumed or an error is encountered.
+func (it *AccountsIterator) Next() bool {
+	if it.done {
+		return false
+	}
+	page, err := it.ac.AccountsPage(&query.PageRequest{Key: it.nextKey, Limit: it.limit})
 	if err != nil {
-		return nil, fmt.Errorf("failed to send http request %s", err)
+		it.err = err
+		it.done = true
+		return false
 	}
-	var resp types.DisabledListResponse
-	data, err := ioutil.ReadAll(res.Body)
+	it.page = page
+	if page.Pagination == nil || len(page.Pagination.NextKey) == 0 {
+		it.done = true
+	} else {
+		it.nextKey = page.Pagination.NextKey
+	}
+	return true
+}
+
+// Returns the page retrieved by the last call to Next
+func (it *AccountsIterator) Page() *types.AccountsResponse {
+	return it.page
+}
+
+// Returns the error encountered during iteration, if any
+func (it *AccountsIterator) Err() error {
+	return it.err
+}
+
+// Trips a circuit, preventing access to the given urls, emitting the `message` via system logs
+func (ac *APIClient) TripCircuit(urls []string, message string) (*Response, error) {
+	return ac.SendPayload(PayloadV1{
+		Urls:      urls,
+		Message:   message,
+		Operation: MODE_TRIP,
+	})
+}
+
+// Resets a circuit, allowing access to the given urls, emitting the `message` via system logs
+func (ac *APIClient) ResetCircuit(urls []string, message string) (*Response, error) {
+	return ac.SendPayload(PayloadV1{
+		Urls:      urls,
+		Message:   message,
+		Operation: MODE_RESET,
+	})
+}
+
+// Trips the circuit for the given urls, scheduling an automatic reset once `ttl` has elapsed.
+// The scheduled reset is included in the response, and may be cancelled with CancelScheduledReset.
+func (ac *APIClient) TripCircuitFor(urls []string, message string, ttl time.Duration) (*Response, error) {
+	return ac.SendPayload(PayloadV1{
+		Urls:      urls,
+		Message:   message,
+		Operation: MODE_TRIP,
+		TTL:       int64(ttl.Seconds()),
+	})
+}
+
+// Returns the circuit groups defined in the api server's configuration. Requires a JWT issued with the circuit:read scope.
+func (ac *APIClient) Groups() ([]CircuitGroup, error) {
+	data, err := ac.sendAuthenticated("GET", "/v1/groups", nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read http response body %s", err)
+		return nil, err
 	}
-	if err = resp.Unmarshal(data); err != nil {
+	var groups []CircuitGroup
+	if err = json.Unmarshal(data, &groups); err != nil {
+		return nil, fmt.Errorf("failed to deserialize http response body %s", err)
+	}
+	return groups, nil
+}
+
+// Trips the circuits of the named groups defined in the api server's configuration, emitting the `message` via system logs
+func (ac *APIClient) TripGroups(groups []string, message string) (*Response, error) {
+	return ac.SendPayload(PayloadV1{
+		Groups:    groups,
+		Message:   message,
+		Operation: MODE_TRIP,
+	})
+}
+
+// Declares the full set of urls whose circuits should be tripped, tripping and resetting circuits as needed in
+// a single transaction. When `state.PlanOnly` is set the required changes are returned without being applied.
+func (ac *APIClient) SetDesiredState(state DesiredState) (*DesiredStateResponse, error) {
+	data, err := ac.sendAuthenticated("PUT", "/v1/circuits/desired", &state)
+	if err != nil {
+		return nil, err
+	}
+	var resp DesiredStateResponse
+	if err = json.Unmarshal(data, &resp); err != nil {
 		return nil, fmt.Errorf("failed to deserialize http response body %s", err)
 	}
 	return &resp, nil
 }
 
-// Returns all accounts that have been granted some form of permission with the circuit breaker module
-func (ac *APIClient) Accounts() (*types.AccountsResponse, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/v1/status/list/accounts", ac.url), &bytes.Buffer{})
+// Returns circuit changes made on chain by something other than the api, detected at or after `since`.
+// A zero `since` returns every retained change. Requires a JWT issued with the circuit:read scope.
+func (ac *APIClient) DriftEvents(since time.Time) ([]DriftEvent, error) {
+	path := "/v1/drift"
+	if !since.IsZero() {
+		path = fmt.Sprintf("%s?since=%s", path, url.QueryEscape(since.Format(time.RFC3339)))
+	}
+	data, err := ac.sendAuthenticated("GET", path, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to construct http request %s", err)
+		return nil, err
 	}
-	res, err := ac.hc.Do(req)
+	var events []DriftEvent
+	if err = json.Unmarshal(data, &events); err != nil {
+		return nil, fmt.Errorf("failed to deserialize http response body %s", err)
+	}
+	return events, nil
+}
+
+// Returns all pending automatic resets. Requires a JWT issued with the circuit:read scope.
+func (ac *APIClient) ScheduledResets() ([]ScheduledReset, error) {
+	data, err := ac.sendAuthenticated("GET", "/v1/resets", nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send http request %s", err)
+		return nil, err
 	}
-	var resp types.AccountsResponse
-	data, err := ioutil.ReadAll(res.Body)
+	var resets []ScheduledReset
+	if err = json.Unmarshal(data, &resets); err != nil {
+		return nil, fmt.Errorf("failed to deserialize http response body %s", err)
+	}
+	return resets, nil
+}
+
+// Cancels a pending automatic reset, leaving its circuits tripped. Requires a JWT issued with the circuit:trip scope.
+func (ac *APIClient) CancelScheduledReset(id string) (*ScheduledReset, error) {
+	data, err := ac.sendAuthenticated("DELETE", fmt.Sprintf("/v1/resets/%s", id), nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read http response body %s", err)
+		return nil, err
 	}
-	if err = resp.Unmarshal(data); err != nil {
+	var reset ScheduledReset
+	if err = json.Unmarshal(data, &reset); err != nil {
+		return nil, fmt.Errorf("failed to deserialize http response body %s", err)
+	}
+	return &reset, nil
+}
+
+// Creates a maintenance window, during which the circuits of the given urls are tripped.
+// Requires a JWT issued with the circuit:trip and circuit:reset scopes.
+func (ac *APIClient) CreateSchedule(req ScheduleRequest) (*Schedule, error) {
+	return ac.sendSchedule("POST", "/v1/schedules", &req)
+}
+
+// Returns all maintenance windows. Requires a JWT issued with the circuit:read scope.
+func (ac *APIClient) Schedules() ([]Schedule, error) {
+	data, err := ac.sendAuthenticated("GET", "/v1/schedules", nil)
+	if err != nil {
+		return nil, err
+	}
+	var schedules []Schedule
+	if err = json.Unmarshal(data, &schedules); err != nil {
+		return nil, fmt.Errorf("failed to deserialize http response body %s", err)
+	}
+	return schedules, nil
+}
+
+// Returns the maintenance window with the given id. Requires a JWT issued with the circuit:read scope.
+func (ac *APIClient) Schedule(id string) (*Schedule, error) {
+	return ac.sendSchedule("GET", fmt.Sprintf("/v1/schedules/%s", id), nil)
+}
+
+// Replaces the maintenance window with the given id. Only the end of an active window may be changed.
+// Requires a JWT issued with the circuit:trip and circuit:reset scopes.
+func (ac *APIClient) UpdateSchedule(id string, req ScheduleRequest) (*Schedule, error) {
+	return ac.sendSchedule("PUT", fmt.Sprintf("/v1/schedules/%s", id), &req)
+}
+
+// Deletes the maintenance window with the given id, which must not be active.
+// Requires a JWT issued with the circuit:trip and circuit:reset scopes.
+func (ac *APIClient) DeleteSchedule(id string) (*Schedule, error) {
+	return ac.sendSchedule("DELETE", fmt.Sprintf("/v1/schedules/%s", id), nil)
+}
+
+// Sends a request to the schedules api, returning the maintenance window in the response
+func (ac *APIClient) sendSchedule(method string, path string, payload interface{}) (*Schedule, error) {
+	data, err := ac.sendAuthenticated(method, path, payload)
+	if err != nil {
+		return nil, err
+	}
+	var schedule Schedule
+	if err = json.Unmarshal(data, &schedule); err != nil {
+		return nil, fmt.Errorf("failed to deserialize http response body %s", err)
+	}
+	return &schedule, nil
+}
+
+// Sends the given payload to the webhook api, which can be used for settings not exposed by
+// TripCircuit and ResetCircuit such as waiting for the transaction to be committed.
+func (ac *APIClient) SendPayload(payload PayloadV1) (*Response, error) {
+	data, err := ac.sendAuthenticated("POST", "/v1/webhook", &payload)
+	if err != nil {
+		return nil, err
+	}
+	var resp Response
+	if err = json.Unmarshal(data, &resp); err != nil {
 		return nil, fmt.Errorf("failed to deserialize http response body %s", err)
 	}
 	return &resp, nil
 }
 
-// Trips a circuit, preventing access to the given urls, emitting the `message` via system logs
-func (ac *APIClient) TripCircuit(urls []string, message string) (*Response, error) {
-	payload := PayloadV1{
-		Urls:      urls,
-		Message:   message,
-		Operation: MODE_TRIP,
+// Sends the given payload to the webhook api for asynchronous execution, returning the queued job
+// without waiting for the transaction to be broadcast. The job can be polled with Job or WaitForJob.
+func (ac *APIClient) SendPayloadAsync(payload PayloadV1) (*Job, error) {
+	data, err := ac.sendAuthenticated("POST", "/v1/webhook?async=true", &payload)
+	if err != nil {
+		return nil, err
+	}
+	// payloads requiring approval are not queued, instead a proposal is created
+	var pending Response
+	if err = json.Unmarshal(data, &pending); err == nil && pending.ProposalID != "" {
+		return nil, fmt.Errorf("payload requires approval, created proposal %s", pending.ProposalID)
+	}
+	var job Job
+	if err = json.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("failed to deserialize http response body %s", err)
 	}
-	data, err := json.Marshal(&payload)
+	return &job, nil
+}
+
+// Returns all proposals awaiting approval, and those executed or expired within the last day.
+// Requires a JWT issued with the circuit:read scope.
+func (ac *APIClient) Approvals() ([]Proposal, error) {
+	data, err := ac.sendAuthenticated("GET", "/v1/approvals", nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to serialize payload %s", err)
+		return nil, err
+	}
+	var proposals []Proposal
+	if err = json.Unmarshal(data, &proposals); err != nil {
+		return nil, fmt.Errorf("failed to deserialize http response body %s", err)
 	}
-	buffer := bytes.NewBuffer(data)
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/v1/webhook", ac.url), buffer)
+	return proposals, nil
+}
+
+// Returns the proposal with the given id. Requires a JWT issued with the circuit:read scope.
+func (ac *APIClient) Approval(id string) (*Proposal, error) {
+	data, err := ac.sendAuthenticated("GET", fmt.Sprintf("/v1/approvals/%s", id), nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to construct http request %s", err)
+		return nil, err
 	}
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer: %s", ac.jwt))
-	res, err := ac.hc.Do(req)
+	var proposal Proposal
+	if err = json.Unmarshal(data, &proposal); err != nil {
+		return nil, fmt.Errorf("failed to deserialize http response body %s", err)
+	}
+	return &proposal, nil
+}
+
+// Approves the proposal with the given id, executing its payload and returning the proposal including
+// the execution result. The caller must be a different identity than the proposer.
+func (ac *APIClient) Approve(id string) (*Proposal, error) {
+	data, err := ac.sendAuthenticated("POST", fmt.Sprintf("/v1/approvals/%s/approve", id), nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send http request %s", err)
+		return nil, err
+	}
+	var proposal Proposal
+	if err = json.Unmarshal(data, &proposal); err != nil {
+		return nil, fmt.Errorf("failed to deserialize http response body %s", err)
 	}
-	return ac.unmarshalResponse(res.Body)
+	return &proposal, nil
 }
 
-// Resets a circuit, allowing access to the given urls, emitting the `message` via system logs
-func (ac *APIClient) ResetCircuit(urls []string, message string) (*Response, error) {
-	payload := PayloadV1{
-		Urls:      urls,
-		Message:   message,
-		Operation: MODE_RESET,
+// Returns the current state of an asynchronous webhook job
+func (ac *APIClient) Job(id string) (*Job, error) {
+	data, err := ac.sendAuthenticated("GET", fmt.Sprintf("/v1/jobs/%s", id), nil)
+	if err != nil {
+		return nil, err
+	}
+	var job Job
+	if err = json.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("failed to deserialize http response body %s", err)
 	}
-	data, err := json.Marshal(&payload)
+	return &job, nil
+}
+
+// Returns entries from the audit log matching `filter`. Requires a JWT issued with the circuit:read scope.
+func (ac *APIClient) Audit(filter AuditFilter) ([]AuditEntry, error) {
+	query := url.Values{}
+	if !filter.Since.IsZero() {
+		query.Set("since", filter.Since.Format(time.RFC3339))
+	}
+	if !filter.Until.IsZero() {
+		query.Set("until", filter.Until.Format(time.RFC3339))
+	}
+	if filter.Url != "" {
+		query.Set("url", filter.Url)
+	}
+	if filter.Identity != "" {
+		query.Set("identity", filter.Identity)
+	}
+	if filter.Limit > 0 {
+		query.Set("limit", strconv.Itoa(filter.Limit))
+	}
+	path := "/v1/audit"
+	if len(query) > 0 {
+		path = fmt.Sprintf("%s?%s", path, query.Encode())
+	}
+	data, err := ac.sendAuthenticated("GET", path, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to serialize payload %s", err)
+		return nil, err
+	}
+	var entries []AuditEntry
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to deserialize http response body %s", err)
+	}
+	return entries, nil
+}
+
+// Polls the job every `pollInterval` until it is committed or failed, returning an error if the job
+// hasn't finished before `timeout` elapses. Temporary failures to query the job are retried.
+func (ac *APIClient) WaitForJob(id string, pollInterval time.Duration, timeout time.Duration) (*Job, error) {
+	exitTimer := time.NewTimer(timeout)
+	defer exitTimer.Stop()
+	checkTicker := time.NewTicker(pollInterval)
+	defer checkTicker.Stop()
+	var lastErr error
+	for {
+		job, err := ac.Job(id)
+		if err == nil && job.Finished() {
+			return job, nil
+		}
+		lastErr = err
+		select {
+		case <-exitTimer.C:
+			if lastErr != nil {
+				return nil, fmt.Errorf("job %s did not finish within %s: %s", id, timeout, lastErr)
+			}
+			return job, fmt.Errorf("job %s did not finish within %s", id, timeout)
+		case <-checkTicker.C:
+		}
+	}
+}
+
+// Grants `address` the given permission level with the circuit breaker module, optionally limited
+// to `limitTypeUrls` when using "LEVEL_SOME_MSGS". Requires a JWT issued with admin access.
+func (ac *APIClient) Authorize(address string, level string, limitTypeUrls []string) (*PermissionsResponse, error) {
+	payload := AuthorizePayload{
+		Level:         level,
+		LimitTypeUrls: limitTypeUrls,
 	}
-	buffer := bytes.NewBuffer(data)
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/v1/webhook", ac.url), buffer)
+	data, err := ac.sendAuthenticated("POST", fmt.Sprintf("/v1/accounts/%s/authorize", address), &payload)
 	if err != nil {
-		return nil, fmt.Errorf("failed to construct http request %s", err)
+		return nil, err
 	}
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer: %s", ac.jwt))
-	res, err := ac.hc.Do(req)
+	var resp PermissionsResponse
+	if err = json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("failed to deserialize http response body %s", err)
+	}
+	return &resp, nil
+}
+
+// Revokes all permissions granted to `address` by the circuit breaker module. Requires a JWT issued with admin access.
+func (ac *APIClient) Revoke(address string) (*PermissionsResponse, error) {
+	data, err := ac.sendAuthenticated("POST", fmt.Sprintf("/v1/accounts/%s/revoke", address), nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send http request %s", err)
+		return nil, err
+	}
+	var resp PermissionsResponse
+	if err = json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("failed to deserialize http response body %s", err)
 	}
-	return ac.unmarshalResponse(res.Body)
+	return &resp, nil
 }
 
-func (ac *APIClient) unmarshalResponse(body io.ReadCloser) (*Response, error) {
-	var resp Response
+// Exchanges the bootstrap credential for a new jwt, which is used by subsequent requests.
+// The api key is sent if configured, otherwise the server verifies the client certificate.
+func (ac *APIClient) RequestToken() (*TokenResponse, error) {
+	ac.tokenMu.Lock()
+	defer ac.tokenMu.Unlock()
+	return ac.requestToken()
+}
 
-	data, err := ioutil.ReadAll(body)
+// Exchanges the current jwt for a new jwt with the same identity and scopes, which is used by subsequent requests.
+func (ac *APIClient) RefreshToken() (*TokenResponse, error) {
+	ac.tokenMu.Lock()
+	defer ac.tokenMu.Unlock()
+	return ac.refreshToken()
+}
+
+// Returns the jwt included in authenticated requests
+func (ac *APIClient) Token() string {
+	ac.tokenMu.Lock()
+	defer ac.tokenMu.Unlock()
+	return ac.jwt
+}
+
+// must be called with the token lock held
+func (ac *APIClient) requestToken() (*TokenResponse, error) {
+	return ac.exchangeToken("/v1/auth/token", &TokenRequest{APIKey: ac.apiKey}, "")
+}
+
+// must be called with the token lock held
+func (ac *APIClient) refreshToken() (*TokenResponse, error) {
+	return ac.exchangeToken("/v1/auth/refresh", nil, ac.jwt)
+}
+
+// must be called with the token lock held
+func (ac *APIClient) exchangeToken(path string, payload interface{}, jwt string) (*TokenResponse, error) {
+	data, err := ac.send("POST", path, payload, jwt, false)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read http response body %s", err)
+		return nil, err
 	}
+	var resp TokenResponse
 	if err = json.Unmarshal(data, &resp); err != nil {
 		return nil, fmt.Errorf("failed to deserialize http response body %s", err)
 	}
+	ac.jwt = resp.Token
 	return &resp, nil
 }
+
+// Renews the jwt if automatic renewal is enabled and it expires within the refresh margin
+func (ac *APIClient) ensureToken() error {
+	if ac.refreshMargin <= 0 || ac.hmacSender != "" {
+		return nil
+	}
+	ac.tokenMu.Lock()
+	defer ac.tokenMu.Unlock()
+	if ac.jwt != "" {
+		// the signature is verified by the server, the client only needs the expiry
+		token, err := jwt.ParseInsecure([]byte(ac.jwt))
+		if err == nil && time.Until(token.Expiration()) > ac.refreshMargin {
+			return nil
+		}
+	}
+	var err error
+	if ac.apiKey != "" || ac.certificateBootstrap {
+		_, err = ac.requestToken()
+	} else {
+		_, err = ac.refreshToken()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to renew jwt %s", err)
+	}
+	return nil
+}
+
+// Sends a request including the jwt or hmac signature, serializing `payload` as json if not nil, and returns the response body.
+// Any non 2xx status code is returned as an error.
+func (ac *APIClient) sendAuthenticated(method string, path string, payload interface{}) ([]byte, error) {
+	if err := ac.ensureToken(); err != nil {
+		return nil, err
+	}
+	return ac.send(method, path, payload, ac.Token(), ac.hmacSender != "")
+}
+
+// Sends a request, serializing `payload` as json if not nil, and returns the response body. The request is
+// signed with the hmac secret if `signHMAC` is true, otherwise `jwt` is included if not empty.
+// Any non 2xx status code is returned as an error.
+func (ac *APIClient) send(method string, path string, payload interface{}, jwt string, signHMAC bool) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to serialize payload %s", err)
+		}
+		buffer = bytes.NewBuffer(data)
+	}
+	body := buffer.Bytes()
+	req, err := http.NewRequest(method, fmt.Sprintf("%s%s", ac.url, path), buffer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct http request %s", err)
+	}
+	if signHMAC {
+		SetHMACHeaders(req, ac.hmacSender, ac.hmacSecret, body)
+	} else if jwt != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer: %s", jwt))
+	}
+	res, err := ac.hc.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send http request %s", err)
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read http response body %s", err)
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf("request failed with status %v: %s", res.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// Sends a request to the unauthenticated status api, decoding the response into `msg`
+// using the content type returned by the server.
+func (ac *APIClient) getStatus(path string, msg protoMessage) error {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s%s", ac.url, path), &bytes.Buffer{})
+	if err != nil {
+		return fmt.Errorf("failed to construct http request %s", err)
+	}
+	req.Header.Set("Accept", ac.accept)
+	res, err := ac.hc.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send http request %s", err)
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read http response body %s", err)
+	}
+	if res.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", errNotFound, strings.TrimSpace(string(data)))
+	} else if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("request failed with status %v: %s", res.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if err = decodeProto(res.Header.Get("Content-Type"), data, msg); err != nil {
+		return fmt.Errorf("failed to deserialize http response body %s", err)
+	}
+	return nil
+}
@@ -12,6 +12,9 @@ import (
 	"testing"
 	"time"
 
+	"cosmossdk.io/x/circuit/types"
+	"github.com/cosmos/cosmos-sdk/crypto/keys/secp256k1"
+	sdktypes "github.com/cosmos/cosmos-sdk/types"
 	"github.com/stretchr/testify/require"
 	"github.com/teamscanworks/breaker/breakerclient"
 	"github.com/teamscanworks/compass"
@@ -52,14 +55,25 @@ func TestAPI(t *testing.T) {
 	}()
 	time.Sleep(time.Second * 2)
 	api.logger.Info("issueing token")
-	jwtToken, err := api.jwt.Encode("apiTest", nil)
+	jwtToken, err := api.jwt.Encode("apiTest", map[string]interface{}{
+		SCOPES_CLAIM: []string{SCOPE_CIRCUIT_TRIP, SCOPE_CIRCUIT_RESET, SCOPE_CIRCUIT_READ},
+	})
 	require.NoError(t, err)
 	api.logger.Info("issued token", zap.String("token", jwtToken))
 	// validate that the test environment setup process worked
-	list, err := api.breakerClient.Accounts(ctx)
+	list, err := api.breakerClient.Accounts(ctx, nil)
 	require.NoError(t, err)
 	require.True(t, len(list.Accounts) > 0)
+	adminToken, err := api.jwt.Encode("apiTestAdmin", map[string]interface{}{
+		SCOPES_CLAIM: []string{SCOPE_ACCOUNTS_ADMIN},
+	})
+	require.NoError(t, err)
+	tripOnlyToken, err := api.jwt.Encode("apiTestMonitor", map[string]interface{}{
+		SCOPES_CLAIM: []string{SCOPE_CIRCUIT_TRIP},
+	})
+	require.NoError(t, err)
 	apiClient := NewAPIClient("http://127.0.0.1:42690", jwtToken)
+	adminClient := NewAPIClient("http://127.0.0.1:42690", adminToken)
 	t.Run("v1", func(t *testing.T) {
 		t.Run("webhook/mode_trip", func(t *testing.T) {
 			resp, err := apiClient.TripCircuit([]string{"/cosmos.circuit.v1.MsgAuthorizeCircuitBreaker"}, "amount > 1000")
@@ -70,6 +84,91 @@ func TestAPI(t *testing.T) {
 			require.NotEmpty(t, resp.TxHash)
 			t.Log(resp)
 		})
+		t.Run("webhook/wait_for_commit", func(t *testing.T) {
+			urls := []string{"/cosmos.bank.v1beta1.MsgMultiSend"}
+			resp, err := apiClient.SendPayload(PayloadV1{
+				Urls:          urls,
+				Message:       "wait for commit",
+				Operation:     MODE_TRIP,
+				WaitForCommit: true,
+			})
+			require.NoError(t, err)
+			require.Equal(t, "ok", resp.Message)
+			require.NotEmpty(t, resp.TxHash)
+			require.True(t, resp.Height > 0)
+			require.Equal(t, uint32(0), resp.Code)
+			require.True(t, resp.GasUsed > 0)
+			require.NotEmpty(t, resp.Fee)
+			resp, err = apiClient.SendPayload(PayloadV1{
+				Urls:          urls,
+				Message:       "wait for commit",
+				Operation:     MODE_RESET,
+				WaitForCommit: true,
+			})
+			require.NoError(t, err)
+			require.Equal(t, "ok", resp.Message)
+			require.True(t, resp.Height > 0)
+		})
+		t.Run("webhook/async", func(t *testing.T) {
+			urls := []string{"/cosmos.bank.v1beta1.MsgMultiSend"}
+			job, err := apiClient.SendPayloadAsync(PayloadV1{Urls: urls, Message: "async", Operation: MODE_TRIP})
+			require.NoError(t, err)
+			require.Equal(t, JOB_QUEUED, job.Status)
+			job, err = apiClient.WaitForJob(job.ID, time.Second, time.Minute)
+			require.NoError(t, err)
+			require.Equal(t, JOB_COMMITTED, job.Status)
+			require.NotEmpty(t, job.TxHash)
+			require.Equal(t, "ok", job.Response.Message)
+			require.True(t, job.Response.Height > 0)
+			resp, err := apiClient.ResetCircuit(urls, "async")
+			require.NoError(t, err)
+			require.Equal(t, "ok", resp.Message)
+		})
+		t.Run("webhook/dry_run", func(t *testing.T) {
+			urls := []string{"/cosmos.bank.v1beta1.MsgMultiSend"}
+			resp, err := apiClient.SendPayload(PayloadV1{Urls: urls, Message: "dry run", Operation: MODE_TRIP, DryRun: true})
+			require.NoError(t, err)
+			require.Equal(t, "ok", resp.Message)
+			require.True(t, resp.DryRun)
+			require.Empty(t, resp.TxHash)
+			require.True(t, resp.GasUsed > 0)
+			require.True(t, resp.GasEstimate >= uint64(resp.GasUsed))
+			// the circuit is not tripped, so simulating a reset fails
+			disabled, err := apiClient.DisabledCommands()
+			require.NoError(t, err)
+			require.NotContains(t, disabled.DisabledList, urls[0])
+			resp, err = apiClient.SendPayload(PayloadV1{Urls: urls, Message: "dry run", Operation: MODE_RESET, DryRun: true})
+			require.NoError(t, err)
+			require.Contains(t, resp.Message, "failed to simulate reset")
+		})
+		t.Run("circuits/desired", func(t *testing.T) {
+			disabled, err := apiClient.DisabledCommands()
+			require.NoError(t, err)
+			desired := append([]string{"/cosmos.bank.v1beta1.MsgMultiSend"}, disabled.DisabledList...)
+			resp, err := apiClient.SetDesiredState(DesiredState{Urls: desired, Message: "plan", PlanOnly: true})
+			require.NoError(t, err)
+			require.Equal(t, []string{"/cosmos.bank.v1beta1.MsgMultiSend"}, resp.Plan.Trip)
+			require.Empty(t, resp.Plan.Reset)
+			require.Empty(t, resp.TxHash)
+
+			resp, err = apiClient.SetDesiredState(DesiredState{Urls: desired, Message: "reconcile", WaitForCommit: true})
+			require.NoError(t, err)
+			require.Equal(t, "ok", resp.Message)
+			require.NotEmpty(t, resp.TxHash)
+			require.NotNil(t, resp.Result)
+			// restoring the previous state resets the circuit in a single transaction
+			resp, err = apiClient.SetDesiredState(DesiredState{Urls: disabled.DisabledList, ResetAll: len(disabled.DisabledList) == 0, Message: "reconcile", WaitForCommit: true})
+			require.NoError(t, err)
+			require.Equal(t, "ok", resp.Message)
+			require.Equal(t, []string{"/cosmos.bank.v1beta1.MsgMultiSend"}, resp.Plan.Reset)
+		})
+		t.Run("webhook/missing_scope", func(t *testing.T) {
+			tripOnlyClient := NewAPIClient("http://127.0.0.1:42690", tripOnlyToken)
+			_, err := tripOnlyClient.ResetCircuit([]string{"/cosmos.circuit.v1.MsgAuthorizeCircuitBreaker"}, "amount > 1000")
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "403")
+			require.Contains(t, err.Error(), "missing required scope circuit:reset")
+		})
 		t.Run("webhook/unsupported_mode", func(t *testing.T) {
 			apiClient.testRequestInvalidMode(t, []string{"/cosmos.circuit.v1.MsgAuthorizeCircuitBreaker"}, "amount > 1000")
 		})
@@ -81,6 +180,18 @@ func TestAPI(t *testing.T) {
 				require.True(t, len(resp.Accounts) > 0)
 				t.Log(resp)
 			})
+			t.Run("list_accounts_iterator", func(t *testing.T) {
+				all, err := apiClient.Accounts()
+				require.NoError(t, err)
+				it := apiClient.AccountsIterator(1)
+				var accounts []*types.GenesisAccountPermissions
+				for it.Next() {
+					require.True(t, len(it.Page().Accounts) <= 1)
+					accounts = append(accounts, it.Page().Accounts...)
+				}
+				require.NoError(t, it.Err())
+				require.Equal(t, len(all.Accounts), len(accounts))
+			})
 			t.Run("list_disabled_commands", func(t *testing.T) {
 				resp, err := apiClient.DisabledCommands()
 				require.NoError(t, err)
@@ -88,6 +199,56 @@ func TestAPI(t *testing.T) {
 				require.True(t, len(resp.DisabledList) > 0)
 				t.Log(resp)
 			})
+			t.Run("list_disabled_commands_protobuf", func(t *testing.T) {
+				protoClient := NewAPIClient("http://127.0.0.1:42690", jwtToken, WithAcceptType(CONTENT_TYPE_PROTOBUF))
+				resp, err := protoClient.DisabledCommands()
+				require.NoError(t, err)
+				require.Equal(t, []string{"/cosmos.circuit.v1.MsgAuthorizeCircuitBreaker"}, resp.DisabledList)
+			})
+		})
+		t.Run("accounts", func(t *testing.T) {
+			grantee := sdktypes.AccAddress(secp256k1.GenPrivKey().PubKey().Address()).String()
+			// waits for the transaction to be committed, so the following queries observe its result
+			waitForTx := func(t *testing.T, txHash string) {
+				result, err := api.breakerClient.WaitForTx(ctx, txHash, time.Second*30)
+				require.NoError(t, err)
+				require.False(t, result.Failed(), result.RawLog)
+			}
+			t.Run("authorize_requires_admin", func(t *testing.T) {
+				_, err := apiClient.Authorize(grantee, "LEVEL_SOME_MSGS", []string{"/cosmos.bank.v1beta1.MsgSend"})
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "missing required scope accounts:admin")
+			})
+			t.Run("authorize", func(t *testing.T) {
+				resp, err := adminClient.Authorize(grantee, "LEVEL_SOME_MSGS", []string{"/cosmos.bank.v1beta1.MsgSend"})
+				require.NoError(t, err)
+				require.Equal(t, "ok", resp.Message)
+				require.Equal(t, grantee, resp.Address)
+				require.NotEmpty(t, resp.TxHash)
+				waitForTx(t, resp.TxHash)
+			})
+			t.Run("authorize_invalid_level", func(t *testing.T) {
+				_, err := adminClient.Authorize(grantee, "LEVEL_FOOBAR", nil)
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "unsupported permission level")
+			})
+			t.Run("status/account", func(t *testing.T) {
+				resp, err := apiClient.Account(grantee)
+				require.NoError(t, err)
+				require.Equal(t, types.Permissions_LEVEL_SOME_MSGS, resp.Permission.Level)
+				require.Equal(t, []string{"/cosmos.bank.v1beta1.MsgSend"}, resp.Permission.LimitTypeUrls)
+			})
+			t.Run("revoke", func(t *testing.T) {
+				resp, err := adminClient.Revoke(grantee)
+				require.NoError(t, err)
+				require.Equal(t, "ok", resp.Message)
+				require.NotEmpty(t, resp.TxHash)
+				waitForTx(t, resp.TxHash)
+			})
+			t.Run("status/account_not_found", func(t *testing.T) {
+				_, err := apiClient.Account(grantee)
+				require.ErrorIs(t, err, ErrNoPermissions)
+			})
 		})
 		t.Run("webhook/mode_reset", func(t *testing.T) {
 			resp, err := apiClient.ResetCircuit([]string{"/cosmos.circuit.v1.MsgAuthorizeCircuitBreaker"}, "amount > 1000")
@@ -0,0 +1,371 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	// message of the webhook response returned when a payload requires approval
+	MESSAGE_PENDING_APPROVAL = "pending approval"
+	// time for which finished proposals can be queried before being removed
+	proposalRetention = time.Hour * 24
+)
+
+// Typed string representing the state of a proposal
+type ProposalStatus string
+
+const (
+	// proposal is waiting for approval
+	PROPOSAL_PENDING ProposalStatus = "pending"
+	// proposal was approved, and the payload is being executed
+	PROPOSAL_APPROVED ProposalStatus = "approved"
+	// proposal was approved, and the payload executed successfully
+	PROPOSAL_EXECUTED ProposalStatus = "executed"
+	// proposal was approved, but the payload failed to execute
+	PROPOSAL_FAILED ProposalStatus = "failed"
+	// proposal was not approved before its ttl elapsed
+	PROPOSAL_EXPIRED ProposalStatus = "expired"
+)
+
+// Configures which webhook operations require approval from a second identity before being executed
+type ApprovalPolicy struct {
+	// if true, resetting circuits requires approval
+	Reset bool
+	// if true, tripping circuits requires approval
+	Trip bool
+	// module request urls which require approval, if empty approval is required for every url
+	Urls []string
+	// time in seconds after which pending proposals expire, defaults to 3600
+	TTLSeconds int64
+}
+
+// Returns true if applying `payload` requires approval
+func (ap *ApprovalPolicy) requires(payload PayloadV1) bool {
+	if (payload.Operation == MODE_RESET && !ap.Reset) || (payload.Operation == MODE_TRIP && !ap.Trip) {
+		return false
+	}
+	if len(ap.Urls) == 0 {
+		return true
+	}
+	for _, url := range payload.Urls {
+		for _, policyUrl := range ap.Urls {
+			if url == policyUrl {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// An approval of a proposal given by an identity
+type Approval struct {
+	Identity   string
+	AuthMethod string
+	ApprovedAt time.Time
+}
+
+// A webhook payload which is pending approval by a second identity
+type Proposal struct {
+	ID      string
+	Status  ProposalStatus
+	Payload PayloadV1
+	// identity which submitted the payload
+	Proposer   string
+	AuthMethod string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	// approvals given for the proposal, in the order they were given
+	Approvals []Approval
+	// result of executing the payload, set once the proposal is approved
+	Response *Response
+}
+
+// In memory store of proposals
+type approvalStore struct {
+	mu        sync.Mutex
+	proposals map[string]*Proposal
+	// identity of the proposer of each proposal, used to execute the payload once approved
+	proposers map[string]Identity
+	// audit intake of the request which created each proposal, which the result of executing it is appended to
+	intakes map[string]auditIntake
+}
+
+func newApprovalStore() *approvalStore {
+	return &approvalStore{
+		proposals: make(map[string]*Proposal),
+		proposers: make(map[string]Identity),
+		intakes:   make(map[string]auditIntake),
+	}
+}
+
+// Creates a pending proposal for the payload submitted by `identity`, returning a copy of the proposal. If the
+// identity already proposed the same operation for the same urls and it is still pending, that proposal is returned
+// instead, such that repeated notifications don't create duplicate proposals. If not nil, `record` is called with
+// the proposal before it can be approved, and returns the audit intake of the request.
+func (as *approvalStore) propose(payload PayloadV1, identity Identity, ttl time.Duration, record func(proposal Proposal) auditIntake) (Proposal, error) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	now := time.Now().UTC()
+	as.expire(now)
+	for id, proposal := range as.proposals {
+		if proposal.Status == PROPOSAL_PENDING &&
+			proposal.Payload.Operation == payload.Operation &&
+			equalUrls(proposal.Payload.Urls, payload.Urls) &&
+			as.proposers[id].Name == identity.Name &&
+			as.proposers[id].Method == identity.Method {
+			// repeated requests are recorded, but the result is appended to the request which created the proposal
+			if record != nil {
+				record(*proposal)
+			}
+			return *proposal, nil
+		}
+	}
+
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return Proposal{}, fmt.Errorf("failed to generate proposal id %s", err)
+	}
+	proposal := &Proposal{
+		ID:         hex.EncodeToString(idBytes),
+		Status:     PROPOSAL_PENDING,
+		Payload:    payload,
+		Proposer:   identity.Name,
+		AuthMethod: identity.Method,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(ttl),
+	}
+	as.proposals[proposal.ID] = proposal
+	as.proposers[proposal.ID] = identity
+	if record != nil {
+		as.intakes[proposal.ID] = record(*proposal)
+	}
+	return *proposal, nil
+}
+
+// Returns the audit intake of the request which created the proposal with the given id
+func (as *approvalStore) intake(id string) auditIntake {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	return as.intakes[id]
+}
+
+// Returns a copy of the proposal with the given id
+func (as *approvalStore) get(id string) (Proposal, bool) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	as.expire(time.Now().UTC())
+	proposal, ok := as.proposals[id]
+	if !ok {
+		return Proposal{}, false
+	}
+	return *proposal, true
+}
+
+// Returns copies of all proposals, ordered by creation time
+func (as *approvalStore) list() []Proposal {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	as.expire(time.Now().UTC())
+	proposals := make([]Proposal, 0, len(as.proposals))
+	for _, proposal := range as.proposals {
+		proposals = append(proposals, *proposal)
+	}
+	sort.Slice(proposals, func(i, j int) bool {
+		return proposals[i].CreatedAt.Before(proposals[j].CreatedAt)
+	})
+	return proposals
+}
+
+// Records the approval of the pending proposal by `approver`, returning the proposal and the identity of
+// its proposer. The approver must have a different name than the proposer, whatever method either authenticated
+// with, as the same person may hold several credentials. Once approved the proposal is no longer pending,
+// preventing it from being executed twice.
+func (as *approvalStore) approve(id string, approver Identity) (Proposal, Identity, error) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	now := time.Now().UTC()
+	as.expire(now)
+	proposal, ok := as.proposals[id]
+	if !ok {
+		return Proposal{}, Identity{}, errProposalNotFound
+	}
+	if proposal.Status != PROPOSAL_PENDING {
+		return Proposal{}, Identity{}, fmt.Errorf("proposal is %s", proposal.Status)
+	}
+	proposer := as.proposers[id]
+	if approver.Name == "" {
+		return Proposal{}, Identity{}, fmt.Errorf("approver has no identity")
+	}
+	if approver.Name == proposer.Name {
+		return Proposal{}, Identity{}, fmt.Errorf("proposal must be approved by a different identity than the proposer")
+	}
+	proposal.Approvals = append(proposal.Approvals, Approval{
+		Identity:   approver.Name,
+		AuthMethod: approver.Method,
+		ApprovedAt: now,
+	})
+	proposal.Status = PROPOSAL_APPROVED
+	return *proposal, proposer, nil
+}
+
+// Records the result of executing an approved proposal
+func (as *approvalStore) finish(id string, response Response) Proposal {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	proposal := as.proposals[id]
+	if response.Message == "ok" {
+		proposal.Status = PROPOSAL_EXECUTED
+	} else {
+		proposal.Status = PROPOSAL_FAILED
+	}
+	proposal.Response = &response
+	return *proposal
+}
+
+// expires pending proposals past their ttl, and removes old finished proposals. must be called with the lock held
+func (as *approvalStore) expire(now time.Time) {
+	for id, proposal := range as.proposals {
+		if proposal.Status == PROPOSAL_PENDING && now.After(proposal.ExpiresAt) {
+			proposal.Status = PROPOSAL_EXPIRED
+		}
+		if proposal.Status != PROPOSAL_PENDING && now.Sub(proposal.ExpiresAt) > proposalRetention {
+			delete(as.proposals, id)
+			delete(as.proposers, id)
+			delete(as.intakes, id)
+		}
+	}
+}
+
+// Returns true if both lists contain the same urls, ignoring order
+func equalUrls(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[string]int, len(a))
+	for _, url := range a {
+		counts[url]++
+	}
+	for _, url := range b {
+		if counts[url] == 0 {
+			return false
+		}
+		counts[url]--
+	}
+	return true
+}
+
+var errProposalNotFound = fmt.Errorf("proposal not found")
+
+type proposalContextKey struct{}
+
+// Returns a copy of ctx containing the approved proposal whose payload is being executed
+func withApprovedProposal(ctx context.Context, proposal Proposal) context.Context {
+	return context.WithValue(ctx, proposalContextKey{}, proposal)
+}
+
+// Returns the approved proposal whose payload is being executed, if any
+func approvedProposalFromContext(ctx context.Context) (Proposal, bool) {
+	proposal, ok := ctx.Value(proposalContextKey{}).(Proposal)
+	return proposal, ok
+}
+
+// Creates a proposal for `payload` if the approval policy requires it, returning the pending response
+// which should be returned to the caller instead of executing the payload. Dry runs never require approval,
+// as simulating a transaction can't change any circuit.
+func (api *API) proposeIfRequired(ctx context.Context, payload PayloadV1) (*Response, error) {
+	if api.dryRun || payload.DryRun {
+		return nil, nil
+	}
+	if api.approvalPolicy == nil || !api.approvalPolicy.requires(payload) {
+		return nil, nil
+	}
+	ttl := time.Hour
+	if api.approvalPolicy.TTLSeconds > 0 {
+		ttl = time.Second * time.Duration(api.approvalPolicy.TTLSeconds)
+	}
+	identity := IdentityFromContext(ctx)
+	proposal, err := api.approvals.propose(payload, identity, ttl, func(proposal Proposal) auditIntake {
+		return api.recordIntake(ctx, payload, &Response{
+			Message:    MESSAGE_PENDING_APPROVAL,
+			ProposalID: proposal.ID,
+		}, http.StatusAccepted)
+	})
+	if err != nil {
+		return nil, err
+	}
+	api.logger.Info("created proposal",
+		zap.String("proposal.id", proposal.ID),
+		zap.Stringer("operation", payload.Operation),
+		zap.Any("urls", payload.Urls),
+		zap.String("message", payload.Message),
+		zap.String("identity", identity.Name),
+	)
+	return &Response{
+		Message:    MESSAGE_PENDING_APPROVAL,
+		Urls:       payload.Urls,
+		Operation:  payload.Operation,
+		ProposalID: proposal.ID,
+	}, nil
+}
+
+// Returns all proposals, including those which have been executed or expired within the last day
+func (api *API) ListApprovals(w http.ResponseWriter, r *http.Request) {
+	proposals := api.approvals.list()
+	api.serveJSON(w, r, &proposals)
+}
+
+// Returns the proposal given by the `id` url parameter
+func (api *API) GetApproval(w http.ResponseWriter, r *http.Request) {
+	proposal, ok := api.approvals.get(chi.URLParam(r, "id"))
+	if !ok {
+		http.Error(w, errProposalNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	api.serveJSON(w, r, &proposal)
+}
+
+// Approves the proposal given by the `id` url parameter, executing its payload. The caller must be a different
+// identity than the proposer, and hold the scope required by the operation of the proposal.
+func (api *API) ApproveProposal(w http.ResponseWriter, r *http.Request) {
+	if api.breakerClient == nil {
+		http.Error(w, "no initialized breaker client", http.StatusInternalServerError)
+		return
+	}
+	id := chi.URLParam(r, "id")
+	approver := IdentityFromContext(r.Context())
+	proposal, ok := api.approvals.get(id)
+	if !ok {
+		http.Error(w, errProposalNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if scope := RequiredScope(proposal.Payload.Operation); !approver.HasScope(scope) {
+		forbidScope(w, scope)
+		return
+	}
+	proposal, proposer, err := api.approvals.approve(id, approver)
+	if err == errProposalNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	api.logger.Info("approved proposal", zap.String("proposal.id", id), zap.String("proposer", proposal.Proposer), zap.String("approver", approver.Name))
+
+	// the payload is executed on behalf of the proposer, recording the approval chain in the audit log
+	ctx := withApprovedProposal(withIdentity(r.Context(), proposer), proposal)
+	ctx = withAuditIntake(ctx, api.approvals.intake(id))
+	response := api.executePayload(ctx, proposal.Payload, nil)
+	proposal = api.approvals.finish(id, response)
+	api.serveJSON(w, r, &proposal)
+}
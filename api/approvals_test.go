@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestApprovalPolicy(t *testing.T) {
+	policy := ApprovalPolicy{Reset: true, Urls: []string{"/cosmos.bank.v1beta1.MsgSend"}}
+	require.True(t, policy.requires(PayloadV1{Urls: []string{"/cosmos.staking.v1beta1.MsgDelegate", "/cosmos.bank.v1beta1.MsgSend"}, Operation: MODE_RESET}))
+	require.False(t, policy.requires(PayloadV1{Urls: []string{"/cosmos.staking.v1beta1.MsgDelegate"}, Operation: MODE_RESET}))
+	require.False(t, policy.requires(PayloadV1{Urls: []string{"/cosmos.bank.v1beta1.MsgSend"}, Operation: MODE_TRIP}))
+
+	policy = ApprovalPolicy{Trip: true}
+	require.True(t, policy.requires(PayloadV1{Urls: []string{"/cosmos.staking.v1beta1.MsgDelegate"}, Operation: MODE_TRIP}))
+	require.False(t, policy.requires(PayloadV1{Urls: []string{"/cosmos.staking.v1beta1.MsgDelegate"}, Operation: MODE_RESET}))
+}
+
+func TestApprovalStore(t *testing.T) {
+	as := newApprovalStore()
+	proposer := Identity{Name: "alice", Method: AUTH_METHOD_JWT, Scopes: []string{SCOPE_CIRCUIT_RESET}}
+	payload := PayloadV1{Message: "exploit patched", Urls: []string{"/cosmos.bank.v1beta1.MsgSend"}, Operation: MODE_RESET}
+	proposal, err := as.propose(payload, proposer, time.Hour, nil)
+	require.NoError(t, err)
+	require.Equal(t, PROPOSAL_PENDING, proposal.Status)
+	require.Equal(t, "alice", proposal.Proposer)
+
+	// proposing the same operation again returns the pending proposal
+	again, err := as.propose(payload, proposer, time.Hour, nil)
+	require.NoError(t, err)
+	require.Equal(t, proposal.ID, again.ID)
+	require.Len(t, as.list(), 1)
+
+	// the proposer can't approve their own proposal
+	_, _, err = as.approve(proposal.ID, proposer)
+	require.ErrorContains(t, err, "different identity")
+	// including when authenticated with a different method, such as an hmac sender with the same name
+	_, _, err = as.approve(proposal.ID, Identity{Name: "alice", Method: AUTH_METHOD_HMAC})
+	require.ErrorContains(t, err, "different identity")
+	_, _, err = as.approve(proposal.ID, Identity{})
+	require.ErrorContains(t, err, "no identity")
+	_, _, err = as.approve("missing", Identity{Name: "bob"})
+	require.ErrorIs(t, err, errProposalNotFound)
+
+	approved, gotProposer, err := as.approve(proposal.ID, Identity{Name: "bob", Method: AUTH_METHOD_HMAC})
+	require.NoError(t, err)
+	require.Equal(t, PROPOSAL_APPROVED, approved.Status)
+	require.Equal(t, proposer, gotProposer)
+	require.Len(t, approved.Approvals, 1)
+	require.Equal(t, "bob", approved.Approvals[0].Identity)
+
+	// proposals can only be approved once
+	_, _, err = as.approve(proposal.ID, Identity{Name: "carol"})
+	require.ErrorContains(t, err, "proposal is approved")
+
+	finished := as.finish(proposal.ID, Response{Message: "ok", TxHash: "ABC"})
+	require.Equal(t, PROPOSAL_EXECUTED, finished.Status)
+	require.Equal(t, "ABC", finished.Response.TxHash)
+
+	t.Run("expiry", func(t *testing.T) {
+		expired, err := as.propose(payload, proposer, -time.Second, nil)
+		require.NoError(t, err)
+		got, ok := as.get(expired.ID)
+		require.True(t, ok)
+		require.Equal(t, PROPOSAL_EXPIRED, got.Status)
+		_, _, err = as.approve(expired.ID, Identity{Name: "bob"})
+		require.ErrorContains(t, err, "proposal is expired")
+
+		// finished proposals are removed once the retention period has elapsed
+		as.mu.Lock()
+		as.expire(time.Now().Add(proposalRetention * 2))
+		as.mu.Unlock()
+		require.Empty(t, as.list())
+	})
+}
+
+func TestApprovalAudit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	audit, err := OpenAuditLog(filepath.Join(t.TempDir(), "audit.jsonl"))
+	require.NoError(t, err)
+	defer audit.Close()
+	api := &API{
+		logger:         zap.NewNop(),
+		audit:          audit,
+		approvalPolicy: &ApprovalPolicy{Reset: true},
+		approvals:      newApprovalStore(),
+	}
+
+	proposer := Identity{Name: "alice", Method: AUTH_METHOD_JWT}
+	payload := PayloadV1{Urls: []string{"/cosmos.bank.v1beta1.MsgSend"}, Operation: MODE_RESET}
+	pending, err := api.proposeIfRequired(withIdentity(ctx, proposer), payload)
+	require.NoError(t, err)
+	require.Equal(t, MESSAGE_PENDING_APPROVAL, pending.Message)
+	require.NotEmpty(t, pending.ProposalID)
+
+	// trips don't require approval
+	pending, err = api.proposeIfRequired(withIdentity(ctx, proposer), PayloadV1{Operation: MODE_TRIP})
+	require.NoError(t, err)
+	require.Nil(t, pending)
+	// nor do dry runs, which can't change any circuit
+	pending, err = api.proposeIfRequired(withIdentity(ctx, proposer), PayloadV1{Urls: payload.Urls, Operation: MODE_RESET, DryRun: true})
+	require.NoError(t, err)
+	require.Nil(t, pending)
+	api.dryRun = true
+	pending, err = api.proposeIfRequired(withIdentity(ctx, proposer), payload)
+	require.NoError(t, err)
+	require.Nil(t, pending)
+	api.dryRun = false
+
+	proposals := api.approvals.list()
+	require.Len(t, proposals, 1)
+	approved, _, err := api.approvals.approve(proposals[0].ID, Identity{Name: "bob", Method: AUTH_METHOD_JWT})
+	require.NoError(t, err)
+
+	// the proposal is recorded when requested, and the execution result appended referencing it
+	intake := api.approvals.intake(approved.ID)
+	require.NotZero(t, intake.ID)
+	ctx = withAuditIntake(withApprovedProposal(withIdentity(ctx, proposer), approved), intake)
+	api.recordAudit(ctx, payload, &Response{Message: "ok", TxHash: "ABC"}, time.Now())
+	entries := audit.Query(AuditFilter{})
+	require.Len(t, entries, 2)
+	require.Equal(t, intake.ID, entries[0].ID)
+	require.Equal(t, http.StatusAccepted, entries[0].StatusCode)
+	require.Equal(t, MESSAGE_PENDING_APPROVAL, entries[0].Result)
+	require.Equal(t, approved.ID, entries[0].ProposalID)
+	require.False(t, entries[0].Success)
+
+	// the approval chain is recorded alongside the proposer, with the time the proposal was requested
+	require.Equal(t, intake.ID, entries[1].RequestEntryID)
+	require.True(t, entries[0].RequestedAt.Equal(entries[1].RequestedAt))
+	require.Equal(t, "alice", entries[1].Identity)
+	require.Equal(t, approved.ID, entries[1].ProposalID)
+	require.Equal(t, []string{"bob"}, entries[1].ApprovedBy)
+	require.Equal(t, "ABC", entries[1].TxHash)
+}
+
+func TestApprovalsRequireIdentifierField(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := ApiOpts{Approvals: &ApprovalPolicy{Reset: true}}
+	_, err := NewAPI(ctx, zap.NewNop(), NewJWT("password123", "", 300), opts, nil)
+	require.ErrorContains(t, err, "identifier_field")
+
+	_, err = NewAPI(ctx, zap.NewNop(), NewJWT("password123", "userId", 300), opts, nil)
+	require.NoError(t, err)
+}
@@ -0,0 +1,469 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cosmos/cosmos-sdk/crypto/keys/ed25519"
+	"github.com/cosmos/cosmos-sdk/crypto/keys/secp256k1"
+	cryptotypes "github.com/cosmos/cosmos-sdk/crypto/types"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
+)
+
+// A record of a single circuit operation applied through the api
+type AuditEntry struct {
+	// sequence number of the entry, starting at 1
+	ID uint64
+	// time at which the operation was requested, and completed. for entries recorded when a request
+	// is rejected or accepted for later execution, the time at which the entry was recorded
+	RequestedAt time.Time
+	CompletedAt time.Time
+	// http status returned to the caller, set when the request was rejected, or accepted for later
+	// execution as an asynchronous job or a proposal requiring approval
+	StatusCode int `json:",omitempty"`
+	// set when recording the execution of a request accepted for later execution, the id of the
+	// entry recorded when the request was received
+	RequestEntryID uint64 `json:",omitempty"`
+	// id of the http request, as set by the request id middleware
+	RequestID string
+	// id of the asynchronous job that executed the operation, if any
+	JobID string `json:",omitempty"`
+	// caller which requested the operation
+	Identity   string
+	AuthMethod string
+	Operation  string
+	Urls       []string
+	// reason for the operation, given by PayloadV1.Message
+	Reason string
+	TxHash string
+	// "ok" if the operation succeeded, otherwise the error message
+	Result  string
+	Success bool
+	Height  int64 `json:",omitempty"`
+	Code    uint32
+	// when the operation required approval, the id of its proposal and the identities which approved it
+	ProposalID string   `json:",omitempty"`
+	ApprovedBy []string `json:",omitempty"`
+	// true if the transaction was simulated instead of broadcast
+	DryRun bool `json:",omitempty"`
+	// hex encoded sha256 hash of the previous entry, empty for the first entry
+	PrevHash string
+	// hex encoded sha256 hash of this entry, see AuditEntry.ComputeHash
+	Hash string
+	// when signing is enabled, the signature of the hash by the breaker's keyring key, and its public key
+	Signature  []byte `json:",omitempty"`
+	PubKey     []byte `json:",omitempty"`
+	PubKeyType string `json:",omitempty"`
+}
+
+// Returns the hex encoded sha256 hash of the json encoding of the entry, excluding the hash and signature fields.
+// As each entry includes the hash of the previous entry, modifying any entry breaks the chain of hashes.
+func (ae AuditEntry) ComputeHash() (string, error) {
+	ae.Hash = ""
+	ae.Signature = nil
+	ae.PubKey = nil
+	ae.PubKeyType = ""
+	data, err := json.Marshal(&ae)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize audit entry %s", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Signs the hash of audit entries, implemented by breakerclient.BreakerClient using the keyring key
+type AuditSigner interface {
+	SignBytes(msg []byte) ([]byte, cryptotypes.PubKey, error)
+}
+
+// Returned by VerifyAuditLog, identifying the first entry which breaks the chain
+type AuditChainError struct {
+	// sequence number of the entry
+	ID uint64
+	// position of the entry in the log, starting at 1
+	Line   int
+	Reason string
+}
+
+func (e *AuditChainError) Error() string {
+	return fmt.Sprintf("audit chain broken at entry %v (line %v): %s", e.ID, e.Line, e.Reason)
+}
+
+// Options used when verifying the audit log
+type AuditVerifyOpts struct {
+	// if true, unsigned entries break the chain
+	RequireSignatures bool
+	// if set, entries signed by any other key break the chain
+	TrustedPubKey cryptotypes.PubKey
+}
+
+// Verifies the chain of hashes of the given entries, and the signature of any signed entries,
+// returning an AuditChainError for the first broken link.
+func VerifyAuditLog(entries []AuditEntry, opts AuditVerifyOpts) error {
+	prevHash := ""
+	for i, entry := range entries {
+		fail := func(reason string) error {
+			return &AuditChainError{ID: entry.ID, Line: i + 1, Reason: reason}
+		}
+		if i > 0 && entry.ID != entries[i-1].ID+1 {
+			return fail(fmt.Sprintf("expected sequence number %v", entries[i-1].ID+1))
+		}
+		if entry.PrevHash != prevHash {
+			return fail("previous hash does not match the hash of the previous entry")
+		}
+		hash, err := entry.ComputeHash()
+		if err != nil {
+			return fail(err.Error())
+		}
+		if entry.Hash != hash {
+			return fail("entry hash does not match its contents")
+		}
+		if len(entry.Signature) > 0 {
+			pubKey, err := auditPubKey(entry.PubKeyType, entry.PubKey)
+			if err != nil {
+				return fail(err.Error())
+			}
+			if opts.TrustedPubKey != nil && !opts.TrustedPubKey.Equals(pubKey) {
+				return fail("entry is signed by an untrusted key")
+			}
+			if !pubKey.VerifySignature([]byte(entry.Hash), entry.Signature) {
+				return fail("invalid signature")
+			}
+		} else if opts.RequireSignatures {
+			return fail("entry is not signed")
+		}
+		prevHash = entry.Hash
+	}
+	return nil
+}
+
+// Returns the public key of the given type
+func auditPubKey(keyType string, key []byte) (cryptotypes.PubKey, error) {
+	switch keyType {
+	case "secp256k1":
+		return &secp256k1.PubKey{Key: key}, nil
+	case "ed25519":
+		return &ed25519.PubKey{Key: key}, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %q", keyType)
+	}
+}
+
+// Filters applied when querying the audit log, zero values match every entry
+type AuditFilter struct {
+	// only entries requested at or after this time
+	Since time.Time
+	// only entries requested before this time
+	Until time.Time
+	// only entries which include this url
+	Url string
+	// only entries requested by this identity
+	Identity string
+	// maximum number of entries to return, starting from the most recent
+	Limit int
+}
+
+// Returns true if the entry matches the filter
+func (af *AuditFilter) matches(entry *AuditEntry) bool {
+	if !af.Since.IsZero() && entry.RequestedAt.Before(af.Since) {
+		return false
+	}
+	if !af.Until.IsZero() && !entry.RequestedAt.Before(af.Until) {
+		return false
+	}
+	if af.Identity != "" && entry.Identity != af.Identity {
+		return false
+	}
+	if af.Url != "" {
+		for _, url := range entry.Urls {
+			if url == af.Url {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Append only audit log of circuit operations, persisted as a file containing one json encoded entry per line.
+// Entries are chained by including the hash of the previous entry, and optionally signed.
+type AuditLog struct {
+	mu      sync.RWMutex
+	file    *os.File
+	entries []AuditEntry
+	// signs the hash of each entry, nil if signing is disabled
+	signer AuditSigner
+}
+
+// Opens the audit log at `path`, creating it if it doesn't exist
+func OpenAuditLog(path string) (*AuditLog, error) {
+	entries, err := ReadAuditLog(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log %s", err)
+	}
+	return &AuditLog{
+		file:    file,
+		entries: entries,
+	}, nil
+}
+
+// Reads all entries from the audit log at `path`
+func ReadAuditLog(path string) ([]AuditEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("failed to parse audit log line %v %s", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log %s", err)
+	}
+	return entries, nil
+}
+
+// Sets the signer used to sign the hash of appended entries
+func (al *AuditLog) SetSigner(signer AuditSigner) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	al.signer = signer
+}
+
+// Assigns the next sequence number to `entry`, chains it to the previous entry, and durably appends it to
+// the log. If signing fails the entry is still appended without a signature, and an error is returned.
+func (al *AuditLog) Append(entry AuditEntry) (AuditEntry, error) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	entry.ID = uint64(len(al.entries)) + 1
+	entry.PrevHash = ""
+	if len(al.entries) > 0 {
+		entry.ID = al.entries[len(al.entries)-1].ID + 1
+		entry.PrevHash = al.entries[len(al.entries)-1].Hash
+	}
+	// normalize timestamps so the hash is unchanged after the entry is read back
+	entry.RequestedAt = entry.RequestedAt.UTC()
+	entry.CompletedAt = entry.CompletedAt.UTC()
+	hash, err := entry.ComputeHash()
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	entry.Hash = hash
+	var signErr error
+	if al.signer != nil {
+		sig, pubKey, err := al.signer.SignBytes([]byte(hash))
+		if err != nil {
+			signErr = err
+		} else {
+			entry.Signature = sig
+			entry.PubKey = pubKey.Bytes()
+			entry.PubKeyType = pubKey.Type()
+		}
+	}
+	data, err := json.Marshal(&entry)
+	if err != nil {
+		return AuditEntry{}, fmt.Errorf("failed to serialize audit entry %s", err)
+	}
+	if _, err := al.file.Write(append(data, '\n')); err != nil {
+		return AuditEntry{}, fmt.Errorf("failed to write audit entry %s", err)
+	}
+	if err := al.file.Sync(); err != nil {
+		return AuditEntry{}, fmt.Errorf("failed to sync audit log %s", err)
+	}
+	al.entries = append(al.entries, entry)
+	if signErr != nil {
+		return entry, fmt.Errorf("appended unsigned audit entry, failed to sign %s", signErr)
+	}
+	return entry, nil
+}
+
+// Returns the entries matching `filter`, ordered from oldest to newest
+func (al *AuditLog) Query(filter AuditFilter) []AuditEntry {
+	al.mu.RLock()
+	defer al.mu.RUnlock()
+	var entries []AuditEntry
+	for i := len(al.entries) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(entries) >= filter.Limit {
+			break
+		}
+		if filter.matches(&al.entries[i]) {
+			entries = append(entries, al.entries[i])
+		}
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries
+}
+
+// Closes the underlying file
+func (al *AuditLog) Close() error {
+	return al.file.Close()
+}
+
+type jobIDContextKey struct{}
+
+type auditIntakeContextKey struct{}
+
+// The audit entry recorded when a webhook request is received
+type auditIntake struct {
+	// id of the entry, zero if the request is executed immediately or the audit log is disabled
+	ID          uint64
+	RequestedAt time.Time
+}
+
+// Returns a copy of ctx containing the audit intake of the request being handled, or executed later
+func withAuditIntake(ctx context.Context, intake auditIntake) context.Context {
+	return context.WithValue(ctx, auditIntakeContextKey{}, intake)
+}
+
+// Returns the audit intake of the request being handled, or executed later, if any
+func auditIntakeFromContext(ctx context.Context) (auditIntake, bool) {
+	intake, ok := ctx.Value(auditIntakeContextKey{}).(auditIntake)
+	return intake, ok
+}
+
+// Returns a copy of ctx containing the id of the job being executed
+func withJobID(ctx context.Context, jobID string) context.Context {
+	return context.WithValue(ctx, jobIDContextKey{}, jobID)
+}
+
+// Records the result of executing `payload` in the audit log, if enabled. When executing a request received
+// earlier, the request time is taken from its intake, and the entry references the entry recorded at intake.
+func (api *API) recordAudit(ctx context.Context, payload PayloadV1, response *Response, requestedAt time.Time) {
+	if api.audit == nil {
+		return
+	}
+	intake, ok := auditIntakeFromContext(ctx)
+	if ok && !intake.RequestedAt.IsZero() {
+		requestedAt = intake.RequestedAt
+	}
+	api.appendAudit(ctx, payload, response, requestedAt, 0, intake.ID)
+}
+
+// Records a webhook request which was rejected with `status`, or accepted for later execution, in the audit log
+// if enabled. Returns the intake of the request, which the result of executing an accepted request is appended to.
+func (api *API) recordIntake(ctx context.Context, payload PayloadV1, response *Response, status int) auditIntake {
+	intake, _ := auditIntakeFromContext(ctx)
+	if intake.RequestedAt.IsZero() {
+		intake.RequestedAt = time.Now().UTC()
+	}
+	if api.audit == nil {
+		return intake
+	}
+	intake.ID = api.appendAudit(ctx, payload, response, intake.RequestedAt, status, 0)
+	return intake
+}
+
+// Appends an entry for `payload` to the audit log, returning its id or zero if it could not be appended
+func (api *API) appendAudit(ctx context.Context, payload PayloadV1, response *Response, requestedAt time.Time, status int, requestEntryID uint64) uint64 {
+	identity := IdentityFromContext(ctx)
+	jobID, _ := ctx.Value(jobIDContextKey{}).(string)
+	var approvedBy []string
+	proposal, _ := approvedProposalFromContext(ctx)
+	for _, approval := range proposal.Approvals {
+		approvedBy = append(approvedBy, approval.Identity)
+	}
+	proposalID := proposal.ID
+	if proposalID == "" {
+		proposalID = response.ProposalID
+	}
+	entry, err := api.audit.Append(AuditEntry{
+		RequestedAt:    requestedAt,
+		CompletedAt:    time.Now().UTC(),
+		StatusCode:     status,
+		RequestEntryID: requestEntryID,
+		RequestID:      middleware.GetReqID(ctx),
+		JobID:          jobID,
+		Identity:       identity.Name,
+		AuthMethod:     identity.Method,
+		Operation:      payload.Operation.String(),
+		Urls:           payload.Urls,
+		Reason:         payload.Message,
+		TxHash:         response.TxHash,
+		Result:         response.Message,
+		Success:        response.Message == "ok",
+		Height:         response.Height,
+		Code:           response.Code,
+		ProposalID:     proposalID,
+		ApprovedBy:     approvedBy,
+		DryRun:         response.DryRun,
+	})
+	if err != nil {
+		// the entry is still appended if only signing failed
+		api.logger.Error("failed to record audit entry", zap.String("tx.hash", response.TxHash), zap.Error(err))
+		return entry.ID
+	}
+	api.logger.Debug("recorded audit entry", zap.Uint64("audit.id", entry.ID))
+	return entry.ID
+}
+
+// Returns entries from the audit log, filtered by the `since` and `until` RFC3339 timestamps,
+// `url`, `identity` and `limit` query parameters.
+func (api *API) GetAudit(w http.ResponseWriter, r *http.Request) {
+	if api.audit == nil {
+		http.Error(w, "audit log is not enabled", http.StatusNotFound)
+		return
+	}
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entries := api.audit.Query(filter)
+	if entries == nil {
+		entries = []AuditEntry{}
+	}
+	api.serveJSON(w, r, &entries)
+}
+
+// Parses the audit filter from the request query parameters
+func parseAuditFilter(r *http.Request) (AuditFilter, error) {
+	query := r.URL.Query()
+	filter := AuditFilter{
+		Url:      query.Get("url"),
+		Identity: query.Get("identity"),
+	}
+	var err error
+	if v := query.Get("since"); v != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return AuditFilter{}, fmt.Errorf("invalid since %s", err)
+		}
+	}
+	if v := query.Get("until"); v != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			return AuditFilter{}, fmt.Errorf("invalid until %s", err)
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 0 {
+			return AuditFilter{}, fmt.Errorf("invalid limit %s", v)
+		}
+	}
+	return filter, nil
+}
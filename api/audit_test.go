@@ -0,0 +1,219 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cosmos/cosmos-sdk/crypto/keys/secp256k1"
+	cryptotypes "github.com/cosmos/cosmos-sdk/crypto/types"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/require"
+	"github.com/teamscanworks/breaker/breakerclient"
+	"go.uber.org/zap"
+)
+
+func TestAuditLog(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	audit, err := OpenAuditLog(path)
+	require.NoError(t, err)
+	api := &API{logger: zap.NewNop(), audit: audit}
+
+	start := time.Now().UTC()
+	monitorCtx := context.WithValue(withIdentity(ctx, Identity{Name: "monitor", Method: AUTH_METHOD_HMAC}), middleware.RequestIDKey, "request-1")
+	api.recordAudit(monitorCtx, PayloadV1{
+		Message:   "bank exploit detected",
+		Urls:      []string{"/cosmos.bank.v1beta1.MsgSend"},
+		Operation: MODE_TRIP,
+	}, &Response{Message: "ok", TxHash: "ABC", Height: 10}, start)
+	operatorCtx := withJobID(withIdentity(ctx, Identity{Name: "operator", Method: AUTH_METHOD_JWT}), "job-1")
+	api.recordAudit(operatorCtx, PayloadV1{
+		Message:   "exploit patched",
+		Urls:      []string{"/cosmos.bank.v1beta1.MsgSend", "/cosmos.staking.v1beta1.MsgDelegate"},
+		Operation: MODE_RESET,
+	}, &Response{Message: "failed to reset circuit breaker"}, start.Add(time.Minute))
+	require.NoError(t, audit.Close())
+
+	// entries are persisted across restarts
+	audit, err = OpenAuditLog(path)
+	require.NoError(t, err)
+	defer audit.Close()
+	api.audit = audit
+
+	entries := audit.Query(AuditFilter{})
+	require.Len(t, entries, 2)
+	require.Equal(t, uint64(1), entries[0].ID)
+	require.Equal(t, "monitor", entries[0].Identity)
+	require.Equal(t, AUTH_METHOD_HMAC, entries[0].AuthMethod)
+	require.Equal(t, "request-1", entries[0].RequestID)
+	require.Equal(t, "trip", entries[0].Operation)
+	require.Equal(t, "bank exploit detected", entries[0].Reason)
+	require.Equal(t, "ABC", entries[0].TxHash)
+	require.True(t, entries[0].Success)
+	require.Equal(t, uint64(2), entries[1].ID)
+	require.Equal(t, "job-1", entries[1].JobID)
+	require.False(t, entries[1].Success)
+
+	require.Len(t, audit.Query(AuditFilter{Url: "/cosmos.staking.v1beta1.MsgDelegate"}), 1)
+	require.Len(t, audit.Query(AuditFilter{Identity: "monitor"}), 1)
+	require.Len(t, audit.Query(AuditFilter{Since: start.Add(time.Second)}), 1)
+	require.Len(t, audit.Query(AuditFilter{Until: start.Add(time.Second)}), 1)
+	latest := audit.Query(AuditFilter{Limit: 1})
+	require.Len(t, latest, 1)
+	require.Equal(t, uint64(2), latest[0].ID)
+
+	entry, err := audit.Append(AuditEntry{Operation: "trip"})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), entry.ID)
+
+	t.Run("filters", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/v1/audit?identity=operator&since="+start.Format(time.RFC3339), nil)
+		filter, err := parseAuditFilter(req)
+		require.NoError(t, err)
+		require.Equal(t, "operator", filter.Identity)
+		require.Equal(t, start.Unix(), filter.Since.Unix())
+
+		req = httptest.NewRequest(http.MethodGet, "/v1/audit?since=yesterday", nil)
+		_, err = parseAuditFilter(req)
+		require.Error(t, err)
+		req = httptest.NewRequest(http.MethodGet, "/v1/audit?limit=-1", nil)
+		_, err = parseAuditFilter(req)
+		require.Error(t, err)
+	})
+}
+
+// signs audit entries with an in memory key
+type testAuditSigner struct {
+	key *secp256k1.PrivKey
+}
+
+func (s testAuditSigner) SignBytes(msg []byte) ([]byte, cryptotypes.PubKey, error) {
+	sig, err := s.key.Sign(msg)
+	return sig, s.key.PubKey(), err
+}
+
+func TestAuditChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	audit, err := OpenAuditLog(path)
+	require.NoError(t, err)
+	signer := testAuditSigner{key: secp256k1.GenPrivKey()}
+	audit.SetSigner(signer)
+	for _, reason := range []string{"exploit detected", "exploit patched", "false alarm"} {
+		_, err := audit.Append(AuditEntry{
+			RequestedAt: time.Now(),
+			CompletedAt: time.Now(),
+			Operation:   "trip",
+			Urls:        []string{"/cosmos.bank.v1beta1.MsgSend"},
+			Reason:      reason,
+			Result:      "ok",
+		})
+		require.NoError(t, err)
+	}
+	require.NoError(t, audit.Close())
+
+	entries, err := ReadAuditLog(path)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	require.Empty(t, entries[0].PrevHash)
+	require.Equal(t, entries[0].Hash, entries[1].PrevHash)
+	require.NoError(t, VerifyAuditLog(entries, AuditVerifyOpts{RequireSignatures: true, TrustedPubKey: signer.key.PubKey()}))
+
+	// appending continues the chain after the log is reopened
+	audit, err = OpenAuditLog(path)
+	require.NoError(t, err)
+	_, err = audit.Append(AuditEntry{Operation: "reset", Reason: "unsigned"})
+	require.NoError(t, err)
+	require.NoError(t, audit.Close())
+	entries, err = ReadAuditLog(path)
+	require.NoError(t, err)
+	require.NoError(t, VerifyAuditLog(entries, AuditVerifyOpts{}))
+
+	var chainErr *AuditChainError
+	err = VerifyAuditLog(entries, AuditVerifyOpts{RequireSignatures: true})
+	require.ErrorAs(t, err, &chainErr)
+	require.Equal(t, uint64(4), chainErr.ID)
+
+	err = VerifyAuditLog(entries[:3], AuditVerifyOpts{TrustedPubKey: secp256k1.GenPrivKey().PubKey()})
+	require.ErrorAs(t, err, &chainErr)
+	require.Equal(t, uint64(1), chainErr.ID)
+
+	// editing an entry is detected, even if its hash is recomputed
+	tampered := append([]AuditEntry{}, entries...)
+	tampered[1].Reason = "routine maintenance"
+	err = VerifyAuditLog(tampered, AuditVerifyOpts{})
+	require.ErrorAs(t, err, &chainErr)
+	require.Equal(t, uint64(2), chainErr.ID)
+	tampered[1].Hash, err = tampered[1].ComputeHash()
+	require.NoError(t, err)
+	err = VerifyAuditLog(tampered, AuditVerifyOpts{})
+	require.ErrorAs(t, err, &chainErr)
+	require.Equal(t, uint64(2), chainErr.ID)
+	require.Contains(t, chainErr.Reason, "invalid signature")
+
+	// removing an entry is detected
+	removed := append(append([]AuditEntry{}, entries[:1]...), entries[2:]...)
+	err = VerifyAuditLog(removed, AuditVerifyOpts{})
+	require.ErrorAs(t, err, &chainErr)
+	require.Equal(t, uint64(3), chainErr.ID)
+}
+
+func TestAuditIntake(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	audit, err := OpenAuditLog(filepath.Join(t.TempDir(), "audit.jsonl"))
+	require.NoError(t, err)
+	defer audit.Close()
+	api := &API{logger: zap.NewNop(), audit: audit, breakerClient: &breakerclient.BreakerClient{}, jobs: newJobStore()}
+	monitor := Identity{Name: "monitor", Method: AUTH_METHOD_HMAC, Scopes: []string{SCOPE_CIRCUIT_TRIP}}
+
+	// rejected calls are recorded with the status returned to the caller
+	for _, tt := range []struct {
+		body   string
+		status int
+	}{
+		{`{"Urls":["/cosmos.bank.v1beta1.MsgSend"],"Operation":1,"Message":"exploit patched"}`, http.StatusForbidden},
+		{`{"Urls":`, http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/webhook", strings.NewReader(tt.body))
+		rec := httptest.NewRecorder()
+		api.HandleWebookV1(rec, req.WithContext(withIdentity(ctx, monitor)))
+		require.Equal(t, tt.status, rec.Code)
+	}
+	entries := audit.Query(AuditFilter{})
+	require.Len(t, entries, 2)
+	require.Equal(t, http.StatusForbidden, entries[0].StatusCode)
+	require.Equal(t, "monitor", entries[0].Identity)
+	require.Equal(t, "reset", entries[0].Operation)
+	require.Equal(t, "exploit patched", entries[0].Reason)
+	require.Contains(t, entries[0].Result, SCOPE_CIRCUIT_RESET)
+	require.False(t, entries[0].Success)
+	require.Equal(t, http.StatusBadRequest, entries[1].StatusCode)
+
+	// queued jobs are recorded when submitted, and their result appended with the submission time
+	requestedAt := time.Now().Add(-time.Minute).UTC()
+	payload := PayloadV1{Urls: []string{"/cosmos.bank.v1beta1.MsgSend"}, Operation: MODE_TRIP}
+	intakeCtx := withAuditIntake(withIdentity(ctx, monitor), auditIntake{RequestedAt: requestedAt})
+	job, err := api.jobs.submit(payload, monitor, "request-1", func(job Job) auditIntake {
+		return api.recordIntake(withJobID(intakeCtx, job.ID), payload, &Response{Message: string(JOB_QUEUED)}, http.StatusAccepted)
+	})
+	require.NoError(t, err)
+	queued := <-api.jobs.queue
+	require.Equal(t, uint64(3), queued.intake.ID)
+	api.recordAudit(withAuditIntake(withJobID(withIdentity(ctx, monitor), job.ID), queued.intake), payload, &Response{Message: "ok", TxHash: "ABC"}, time.Now())
+
+	entries = audit.Query(AuditFilter{})
+	require.Len(t, entries, 4)
+	require.Equal(t, http.StatusAccepted, entries[2].StatusCode)
+	require.Equal(t, job.ID, entries[2].JobID)
+	require.Equal(t, uint64(3), entries[3].RequestEntryID)
+	require.Equal(t, job.ID, entries[3].JobID)
+	require.Zero(t, entries[3].StatusCode)
+	require.True(t, entries[3].Success)
+	require.True(t, requestedAt.Equal(entries[3].RequestedAt))
+}
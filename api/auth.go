@@ -0,0 +1,268 @@
+package api
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"go.uber.org/zap"
+)
+
+const (
+	// jwt claim recording the bootstrap credential a token was issued for, ex: "api_key:monitoring"
+	BOOTSTRAP_CLAIM = "bootstrap"
+	// jwt claim recording the unix time at which the bootstrap credential was exchanged, which refreshed tokens inherit
+	ORIGINAL_ISSUED_AT_CLAIM = "orig_iat"
+	// jwt claim recording the jti of the token issued for the bootstrap credential, which refreshed tokens inherit
+	ROOT_JTI_CLAIM = "root_jti"
+	// prefix of the bootstrap claim for tokens issued in exchange for an api key
+	bootstrapAPIKey = "api_key:"
+	// prefix of the bootstrap claim for tokens issued in exchange for a client certificate
+	bootstrapCertificate = "certificate:"
+)
+
+// A long lived credential which may be exchanged for a short lived jwt
+type APIKey struct {
+	// name of the key, used as the identity of issued tokens
+	Name string
+	// secret value of the key
+	Key string
+	// scopes granted to tokens issued for the key
+	Scopes []string
+}
+
+// A client certificate which may be exchanged for a short lived jwt. The certificate
+// must be signed by the configured client certificate authority.
+type ClientCertificate struct {
+	// common name of the certificate subject, used as the identity of issued tokens
+	CommonName string
+	// scopes granted to tokens issued for the certificate
+	Scopes []string
+}
+
+// The payload sent to `/v1/auth/token`. When the api key is empty, the client certificate
+// presented during the tls handshake is used instead.
+type TokenRequest struct {
+	APIKey string
+}
+
+// A response returned from the token issuance and refresh apis
+type TokenResponse struct {
+	Token     string
+	ExpiresAt time.Time
+	Scopes    []string
+}
+
+// Exchanges an api key or client certificate for a short lived jwt
+func (api *API) IssueToken(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var payload TokenRequest
+	if len(data) > 0 {
+		if err = json.Unmarshal(data, &payload); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var (
+		name      string
+		bootstrap string
+		scopes    []string
+	)
+	if payload.APIKey != "" {
+		key, ok := api.lookupAPIKey(payload.APIKey)
+		if !ok {
+			api.logger.Warn("rejected token request with invalid api key")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		name, bootstrap, scopes = key.Name, bootstrapAPIKey+key.Name, key.Scopes
+	} else {
+		cert, ok := api.lookupClientCertificate(r)
+		if !ok {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		name, bootstrap, scopes = cert.CommonName, bootstrapCertificate+cert.CommonName, cert.Scopes
+	}
+	api.issueToken(w, name, scopes, map[string]interface{}{
+		BOOTSTRAP_CLAIM:          bootstrap,
+		ORIGINAL_ISSUED_AT_CLAIM: time.Now().Unix(),
+	}, api.issuedTokenValidity)
+}
+
+// Issues a new short lived jwt to the caller, with the same identity as the jwt used to authenticate, and the scopes
+// currently configured for its bootstrap credential, so that narrowing them applies on the next refresh. Only tokens issued by `/v1/auth/token` may be refreshed, while their bootstrap credential is still configured, and
+// until the maximum lifetime has elapsed since the credential was exchanged. Refreshed tokens inherit the jti of the
+// token issued for the credential as their root jti, so revoking it revokes every token refreshed from it.
+func (api *API) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	identity := IdentityFromContext(r.Context())
+	if identity.Method != AUTH_METHOD_JWT {
+		http.Error(w, "only jwt authenticated callers may refresh tokens", http.StatusBadRequest)
+		return
+	}
+	token, _, err := jwtauth.FromContext(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var bootstrap string
+	if v, ok := token.Get(BOOTSTRAP_CLAIM); ok {
+		bootstrap = fmt.Sprint(v)
+	}
+	originalIssuedAt, ok := claimTime(token, ORIGINAL_ISSUED_AT_CLAIM)
+	if bootstrap == "" || !ok {
+		http.Error(w, "only tokens issued via /v1/auth/token may be refreshed", http.StatusBadRequest)
+		return
+	}
+	scopes, ok := api.bootstrapScopes(bootstrap)
+	if !ok {
+		api.logger.Warn("rejected refresh of token issued for removed credential", zap.String("bootstrap", bootstrap))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	// refreshed tokens never outlive the maximum lifetime, after which the bootstrap credential must be exchanged again
+	validity := api.issuedTokenValidity
+	if remaining := int64(time.Until(originalIssuedAt.Add(time.Second*time.Duration(api.issuedTokenMaxLifetime))) / time.Second); remaining < validity {
+		validity = remaining
+	}
+	if validity <= 0 {
+		api.logger.Warn("rejected refresh of token which reached its maximum lifetime", zap.String("bootstrap", bootstrap), zap.String("jwt.id", token.JwtID()))
+		http.Error(w, "token has reached its maximum lifetime, request a new token", http.StatusUnauthorized)
+		return
+	}
+	rootJTI := token.JwtID()
+	if v, ok := token.Get(ROOT_JTI_CLAIM); ok {
+		rootJTI = fmt.Sprint(v)
+	}
+	api.issueToken(w, identity.Name, scopes, map[string]interface{}{
+		BOOTSTRAP_CLAIM:          bootstrap,
+		ORIGINAL_ISSUED_AT_CLAIM: originalIssuedAt.Unix(),
+		ROOT_JTI_CLAIM:           rootJTI,
+	}, validity)
+}
+
+// Encodes and writes a short lived jwt for the given identity, valid for `validity` seconds. The token is not
+// recorded in the token store, which is written by the cli, so is only identified by the jti in the logs.
+func (api *API) issueToken(w http.ResponseWriter, name string, scopes []string, claims map[string]interface{}, validity int64) {
+	claims[SCOPES_CLAIM] = scopes
+	encoded, token, err := api.jwt.encode(name, claims, validity, false)
+	if err != nil {
+		api.logger.Error("failed to issue token", zap.Error(err))
+		http.Error(w, "failed to issue token", http.StatusInternalServerError)
+		return
+	}
+	rootJTI := token.JwtID()
+	if v, ok := claims[ROOT_JTI_CLAIM]; ok {
+		rootJTI = fmt.Sprint(v)
+	}
+	api.logger.Info("issued token",
+		zap.String("identity", name),
+		zap.Any("bootstrap", claims[BOOTSTRAP_CLAIM]),
+		zap.String("jwt.id", token.JwtID()),
+		zap.String("jwt.root.id", rootJTI),
+	)
+	api.writeJSON(w, http.StatusOK, &TokenResponse{
+		Token:     encoded,
+		ExpiresAt: token.Expiration().UTC(),
+		Scopes:    scopes,
+	})
+}
+
+// Returns the api key with the given secret value
+func (api *API) lookupAPIKey(value string) (APIKey, bool) {
+	for _, key := range api.apiKeys {
+		if subtle.ConstantTimeCompare([]byte(key.Key), []byte(value)) == 1 {
+			return key, true
+		}
+	}
+	return APIKey{}, false
+}
+
+// Returns the configured client certificate matching the verified certificate presented by the caller
+func (api *API) lookupClientCertificate(r *http.Request) (ClientCertificate, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ClientCertificate{}, false
+	}
+	commonName := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	for _, cert := range api.clientCertificates {
+		if cert.CommonName == commonName {
+			return cert, true
+		}
+	}
+	return ClientCertificate{}, false
+}
+
+// Returns the scopes currently configured for the bootstrap credential recorded in a token, and false if the
+// credential is no longer configured
+func (api *API) bootstrapScopes(bootstrap string) ([]string, bool) {
+	switch {
+	case strings.HasPrefix(bootstrap, bootstrapAPIKey):
+		name := strings.TrimPrefix(bootstrap, bootstrapAPIKey)
+		for _, key := range api.apiKeys {
+			if key.Name == name {
+				return key.Scopes, true
+			}
+		}
+	case strings.HasPrefix(bootstrap, bootstrapCertificate):
+		commonName := strings.TrimPrefix(bootstrap, bootstrapCertificate)
+		for _, cert := range api.clientCertificates {
+			if cert.CommonName == commonName {
+				return cert.Scopes, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Returns the time stored as unix seconds in a numeric claim of `token`
+func claimTime(token jwt.Token, claim string) (time.Time, bool) {
+	v, ok := token.Get(claim)
+	if !ok {
+		return time.Time{}, false
+	}
+	switch n := v.(type) {
+	case float64:
+		return time.Unix(int64(n), 0), true
+	case int64:
+		return time.Unix(n, 0), true
+	case json.Number:
+		i, err := n.Int64()
+		return time.Unix(i, 0), err == nil
+	default:
+		return time.Time{}, false
+	}
+}
+
+// Returns the tls configuration used to serve the api. When `clientCAFile` is set, clients may present a
+// certificate signed by the authority, which can be exchanged for a jwt.
+func newTLSConfig(clientCAFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if clientCAFile == "" {
+		return tlsConfig, nil
+	}
+	data, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client ca file %s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("failed to parse client ca file %s", clientCAFile)
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	return tlsConfig, nil
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestTokenIssuance(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api, err := NewAPI(ctx, zap.NewNop(), NewJWT("password123", "userId", 3000), ApiOpts{
+		APIKeys: []APIKey{
+			{Name: "monitoring", Key: "key123", Scopes: []string{SCOPE_CIRCUIT_READ}},
+		},
+		IssuedTokenValiditySeconds: 60,
+	}, nil)
+	require.NoError(t, err)
+	// client certificates require tls, which is simulated by setting the verified chain of requests
+	api.clientCertificates = []ClientCertificate{
+		{CommonName: "deployer", Scopes: []string{SCOPE_CIRCUIT_TRIP}},
+	}
+	server := httptest.NewServer(api.router)
+	defer server.Close()
+
+	t.Run("api_key", func(t *testing.T) {
+		client := NewAPIClient(server.URL, "", WithAPIKey("key123"))
+		require.Empty(t, client.Token())
+		// the token is acquired before sending the request, which fails as the job doesn't exist
+		_, err := client.Job("missing")
+		require.ErrorContains(t, err, "job not found")
+		token := client.Token()
+		require.NotEmpty(t, token)
+		decoded, err := api.jwt.Decode(ctx, token)
+		require.NoError(t, err)
+		require.Equal(t, "api_key:monitoring", decoded.PrivateClaims()[BOOTSTRAP_CLAIM])
+		require.WithinDuration(t, time.Now().Add(time.Minute), decoded.Expiration(), time.Second*5)
+
+		invalid := NewAPIClient(server.URL, "", WithAPIKey("invalid"))
+		_, err = invalid.RequestToken()
+		require.ErrorContains(t, err, "401")
+	})
+	t.Run("refresh", func(t *testing.T) {
+		bootstrap := NewAPIClient(server.URL, "", WithAPIKey("key123"))
+		issued, err := bootstrap.RequestToken()
+		require.NoError(t, err)
+		require.Equal(t, []string{SCOPE_CIRCUIT_READ}, issued.Scopes)
+
+		// a margin longer than the token validity renews the token before every request
+		client := NewAPIClient(server.URL, issued.Token, WithAutoRefresh(time.Hour))
+		_, err = client.Job("missing")
+		require.ErrorContains(t, err, "job not found")
+		require.NotEqual(t, issued.Token, client.Token())
+
+		refreshed, err := client.RefreshToken()
+		require.NoError(t, err)
+		require.Equal(t, []string{SCOPE_CIRCUIT_READ}, refreshed.Scopes)
+
+		// refreshed tokens take the scopes currently configured for their api key
+		keys := api.apiKeys
+		defer func() { api.apiKeys = keys }()
+		api.apiKeys = []APIKey{{Name: "monitoring", Key: "key123", Scopes: []string{SCOPE_CIRCUIT_TRIP}}}
+		refreshed, err = client.RefreshToken()
+		require.NoError(t, err)
+		require.Equal(t, []string{SCOPE_CIRCUIT_TRIP}, refreshed.Scopes)
+
+		// tokens can't be refreshed once their api key is removed
+		api.apiKeys = nil
+		_, err = client.RefreshToken()
+		require.ErrorContains(t, err, "401")
+	})
+	t.Run("refresh_limits", func(t *testing.T) {
+		// tokens not issued for a bootstrap credential, such as those issued by issue-jwt, can't be refreshed
+		issued, err := api.jwt.Encode("operator", map[string]interface{}{SCOPES_CLAIM: []string{SCOPE_CIRCUIT_READ}})
+		require.NoError(t, err)
+		client := NewAPIClient(server.URL, issued)
+		_, err = client.RefreshToken()
+		require.ErrorContains(t, err, "400")
+
+		// refreshed tokens never outlive the maximum lifetime
+		expiring, err := api.jwt.Encode("monitoring", map[string]interface{}{
+			SCOPES_CLAIM:             []string{SCOPE_CIRCUIT_READ},
+			BOOTSTRAP_CLAIM:          "api_key:monitoring",
+			ORIGINAL_ISSUED_AT_CLAIM: time.Now().Add(-time.Second * time.Duration(api.issuedTokenMaxLifetime-30)).Unix(),
+		})
+		require.NoError(t, err)
+		client = NewAPIClient(server.URL, expiring)
+		refreshed, err := client.RefreshToken()
+		require.NoError(t, err)
+		require.WithinDuration(t, time.Now().Add(time.Second*30), refreshed.ExpiresAt, time.Second*5)
+		expired, err := api.jwt.Encode("monitoring", map[string]interface{}{
+			SCOPES_CLAIM:             []string{SCOPE_CIRCUIT_READ},
+			BOOTSTRAP_CLAIM:          "api_key:monitoring",
+			ORIGINAL_ISSUED_AT_CLAIM: time.Now().Add(-time.Second * time.Duration(api.issuedTokenMaxLifetime)).Unix(),
+		})
+		require.NoError(t, err)
+		client = NewAPIClient(server.URL, expired)
+		_, err = client.RefreshToken()
+		require.ErrorContains(t, err, "maximum lifetime")
+
+		// revoking the token issued for the credential revokes every token refreshed from it
+		path := filepath.Join(t.TempDir(), "tokens.json")
+		tokens, err := OpenTokenStore(path)
+		require.NoError(t, err)
+		api.jwt.SetTokenStore(tokens)
+		defer api.jwt.SetTokenStore(nil)
+		bootstrap := NewAPIClient(server.URL, "", WithAPIKey("key123"))
+		root, err := bootstrap.RequestToken()
+		require.NoError(t, err)
+		client = NewAPIClient(server.URL, root.Token)
+		_, err = client.RefreshToken()
+		require.NoError(t, err)
+		_, err = client.RefreshToken()
+		require.NoError(t, err)
+		// tokens issued by the api aren't recorded, as the token store is written by the cli
+		_, err = os.Stat(path)
+		require.ErrorIs(t, err, os.ErrNotExist)
+		rootToken, err := api.jwt.Decode(ctx, root.Token)
+		require.NoError(t, err)
+		refreshedToken, err := api.jwt.Decode(ctx, client.Token())
+		require.NoError(t, err)
+		require.NoError(t, api.jwt.CheckToken(refreshedToken))
+		require.NoError(t, tokens.Revoke(rootToken.JwtID()))
+		require.ErrorContains(t, api.jwt.CheckToken(refreshedToken), "revoked")
+		_, err = client.RefreshToken()
+		require.ErrorContains(t, err, "401")
+	})
+	t.Run("client_certificate", func(t *testing.T) {
+		send := func(commonName string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, "/v1/auth/token", nil)
+			if commonName != "" {
+				req.TLS = &tls.ConnectionState{
+					VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: commonName}}}},
+				}
+			}
+			rec := httptest.NewRecorder()
+			api.IssueToken(rec, req)
+			return rec
+		}
+		rec := send("deployer")
+		require.Equal(t, http.StatusOK, rec.Code)
+		var resp TokenResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Equal(t, []string{SCOPE_CIRCUIT_TRIP}, resp.Scopes)
+
+		require.Equal(t, http.StatusUnauthorized, send("unknown").Code)
+		require.Equal(t, http.StatusUnauthorized, send("").Code)
+	})
+}
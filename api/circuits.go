@@ -0,0 +1,248 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/teamscanworks/breaker/breakerclient"
+	"go.uber.org/zap"
+)
+
+// The payload sent to `PUT /v1/circuits/desired`, declaring the full set of module request urls whose
+// circuits should be tripped. Circuits of any other urls are reset.
+type DesiredState struct {
+	// reason for the change, recorded in the audit log
+	Message string
+	// module request urls, or glob patterns, which should be tripped
+	Urls []string
+	// names of configured circuit groups whose urls should be tripped
+	Groups []string `json:",omitempty"`
+	// if true, the plan is computed and returned without being applied
+	PlanOnly bool `json:"plan_only,omitempty"`
+	// if true, waits for the transaction to be committed and includes the execution result in the response
+	WaitForCommit bool
+	// if true, the transaction is simulated instead of being broadcast
+	DryRun bool `json:"dry_run,omitempty"`
+	// must be set to reset every circuit when no urls or groups are given, so that an empty
+	// or malformed request isn't mistaken for an empty desired state
+	ResetAll bool `json:"reset_all,omitempty"`
+}
+
+// The changes required to reach a desired state
+type CircuitPlan struct {
+	// urls which are not tripped, but should be
+	Trip []string
+	// urls which are tripped, but should not be
+	Reset []string
+	// urls which are already tripped
+	Unchanged []string
+}
+
+// Returns true if no circuits need to be tripped or reset
+func (cp *CircuitPlan) Empty() bool {
+	return len(cp.Trip) == 0 && len(cp.Reset) == 0
+}
+
+// Returned by `PUT /v1/circuits/desired`
+type DesiredStateResponse struct {
+	// if no errors set to "ok", otherwise includes the error
+	Message  string
+	Plan     CircuitPlan
+	PlanOnly bool `json:",omitempty"`
+	// glob patterns and circuit groups of the request, which were expanded into the desired urls
+	Patterns []string            `json:",omitempty"`
+	Groups   map[string][]string `json:",omitempty"`
+	// hash of the transaction applying the plan, empty if no transaction was sent
+	TxHash string `json:",omitempty"`
+	// execution result of the transaction, only set when waiting for it to be committed
+	Result *breakerclient.TxResult `json:",omitempty"`
+	// set when the transaction was simulated instead of broadcast
+	DryRun     bool                            `json:",omitempty"`
+	Simulation *breakerclient.SimulationResult `json:",omitempty"`
+}
+
+// Returns the changes required for exactly the `desired` urls to be tripped, given the currently `disabled` urls
+func PlanCircuits(desired []string, disabled []string) CircuitPlan {
+	isDesired := make(map[string]bool, len(desired))
+	for _, url := range desired {
+		isDesired[url] = true
+	}
+	isDisabled := make(map[string]bool, len(disabled))
+	for _, url := range disabled {
+		isDisabled[url] = true
+	}
+	plan := CircuitPlan{Trip: []string{}, Reset: []string{}, Unchanged: []string{}}
+	for url := range isDesired {
+		if isDisabled[url] {
+			plan.Unchanged = append(plan.Unchanged, url)
+		} else {
+			plan.Trip = append(plan.Trip, url)
+		}
+	}
+	for url := range isDisabled {
+		if !isDesired[url] {
+			plan.Reset = append(plan.Reset, url)
+		}
+	}
+	sort.Strings(plan.Trip)
+	sort.Strings(plan.Reset)
+	sort.Strings(plan.Unchanged)
+	return plan
+}
+
+// Diffs the desired state against the currently tripped circuits, and trips and resets circuits as needed in a
+// single transaction. When `plan_only` is set the plan is returned without being applied, which only requires the
+// circuit:read scope. Otherwise the circuit:trip and circuit:reset scopes are required for the circuits the plan
+// trips and resets. Circuits requiring approval can't be changed through this endpoint.
+//
+// Requests containing unknown fields are rejected, as are requests without urls or groups unless `reset_all` is set.
+func (api *API) SetDesiredState(w http.ResponseWriter, r *http.Request) {
+	if api.breakerClient == nil {
+		http.Error(w, "no initialized breaker client", http.StatusInternalServerError)
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req DesiredState
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Urls) == 0 && len(req.Groups) == 0 && !req.ResetAll {
+		http.Error(w, "no urls or groups given, set reset_all to reset every circuit", http.StatusBadRequest)
+		return
+	}
+	identity := IdentityFromContext(r.Context())
+	if req.PlanOnly && !identity.HasScope(SCOPE_CIRCUIT_READ) {
+		forbidScope(w, SCOPE_CIRCUIT_READ)
+		return
+	}
+
+	urls, groups, err := api.resolveGroups(req.Urls, req.Groups)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	urls, patterns, err := api.expandUrls(r.Context(), urls)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if api.rejectInvalidUrls(w, r, urls) {
+		return
+	}
+
+	disabled, err := api.breakerClient.ListDisabledCommands(r.Context())
+	if err != nil {
+		api.logger.Error("failed to list disabled commands", zap.Error(err))
+		http.Error(w, "failed to list disabled commands", http.StatusInternalServerError)
+		return
+	}
+	response := DesiredStateResponse{
+		Plan:     PlanCircuits(urls, disabled.DisabledList),
+		PlanOnly: req.PlanOnly,
+		Patterns: patterns,
+		Groups:   groups,
+	}
+	if req.PlanOnly || response.Plan.Empty() {
+		response.Message = "ok"
+		api.serveJSON(w, r, &response)
+		return
+	}
+
+	if len(response.Plan.Trip) > 0 && !identity.HasScope(SCOPE_CIRCUIT_TRIP) {
+		forbidScope(w, SCOPE_CIRCUIT_TRIP)
+		return
+	}
+	if len(response.Plan.Reset) > 0 && !identity.HasScope(SCOPE_CIRCUIT_RESET) {
+		forbidScope(w, SCOPE_CIRCUIT_RESET)
+		return
+	}
+	if api.approvalPolicy != nil &&
+		(api.approvalPolicy.requires(PayloadV1{Urls: response.Plan.Trip, Operation: MODE_TRIP}) ||
+			api.approvalPolicy.requires(PayloadV1{Urls: response.Plan.Reset, Operation: MODE_RESET})) {
+		http.Error(w, "circuits requiring approval can't be changed through the desired state, use the webhook instead", http.StatusBadRequest)
+		return
+	}
+
+	api.applyPlan(r.Context(), req, &response)
+	api.serveJSON(w, r, &response)
+}
+
+// Trips and resets circuits according to the plan of `response` in a single transaction, recording the result in `response`
+func (api *API) applyPlan(ctx context.Context, req DesiredState, response *DesiredStateResponse) {
+	requestedAt := time.Now().UTC()
+	plan := response.Plan
+	// the trip and reset are recorded as separate audit entries, sharing the transaction hash
+	defer func() {
+		audited := Response{Message: response.Message, TxHash: response.TxHash, DryRun: response.DryRun}
+		if response.Result != nil {
+			audited.Height = response.Result.Height
+			audited.Code = response.Result.Code
+		}
+		if len(plan.Trip) > 0 {
+			api.recordAudit(ctx, PayloadV1{Message: req.Message, Urls: plan.Trip, Operation: MODE_TRIP}, &audited, requestedAt)
+		}
+		if len(plan.Reset) > 0 {
+			api.recordAudit(ctx, PayloadV1{Message: req.Message, Urls: plan.Reset, Operation: MODE_RESET}, &audited, requestedAt)
+		}
+	}()
+	logger := api.logger.With(zap.Any("trip", plan.Trip), zap.Any("reset", plan.Reset), zap.String("message", req.Message))
+
+	if api.dryRun || req.DryRun {
+		response.DryRun = true
+		simulation, err := api.breakerClient.SimulateUpdateCircuits(ctx, plan.Trip, plan.Reset)
+		if err != nil {
+			response.Message = fmt.Sprintf("failed to simulate desired state %s", err)
+			logger.Error("failed to simulate desired state", zap.Error(err))
+			return
+		}
+		response.Message = "ok"
+		response.Simulation = simulation
+		logger.Info("simulated desired state", zap.Uint64("gas.estimate", simulation.GasEstimate))
+		return
+	}
+
+	api.drift.expect(MODE_TRIP, plan.Trip)
+	api.drift.expect(MODE_RESET, plan.Reset)
+	tx, err := api.breakerClient.UpdateCircuits(ctx, plan.Trip, plan.Reset)
+	if err != nil {
+		api.drift.forget(MODE_TRIP, plan.Trip)
+		api.drift.forget(MODE_RESET, plan.Reset)
+		response.Message = fmt.Sprintf("failed to apply desired state %s", err)
+		logger.Error("failed to apply desired state", zap.Error(err))
+		return
+	}
+	response.TxHash = tx
+	if api.waitForCommit || req.WaitForCommit {
+		result, err := api.breakerClient.WaitForTx(ctx, tx, api.commitTimeout)
+		api.forgetFailedTx(tx, result, plan.Trip, plan.Reset)
+		if err != nil {
+			response.Message = fmt.Sprintf("failed to confirm transaction %s", err)
+			logger.Error("failed to confirm transaction", zap.String("tx.hash", tx), zap.Error(err))
+			return
+		}
+		response.Result = result
+		if result.Failed() {
+			response.Message = fmt.Sprintf("transaction failed with code %v (codespace %s): %s", result.Code, result.Codespace, result.RawLog)
+			logger.Error("transaction failed", zap.String("tx.hash", tx), zap.Uint32("code", result.Code), zap.String("raw.log", result.RawLog))
+			return
+		}
+	} else {
+		api.forgetFailedTx(tx, nil, plan.Trip, plan.Reset)
+	}
+	response.Message = "ok"
+	identity := IdentityFromContext(ctx)
+	logger.Info("applied desired state", zap.String("tx.hash", tx), zap.String("identity", identity.Name), zap.String("auth.method", identity.Method))
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/teamscanworks/breaker/breakerclient"
+	"go.uber.org/zap"
+)
+
+func TestPlanCircuits(t *testing.T) {
+	plan := PlanCircuits(
+		[]string{"/cosmos.bank.v1beta1.MsgSend", "/cosmos.staking.v1beta1.MsgDelegate", "/cosmos.bank.v1beta1.MsgSend"},
+		[]string{"/cosmos.staking.v1beta1.MsgDelegate", "/cosmos.gov.v1.MsgVote"},
+	)
+	require.Equal(t, []string{"/cosmos.bank.v1beta1.MsgSend"}, plan.Trip)
+	require.Equal(t, []string{"/cosmos.gov.v1.MsgVote"}, plan.Reset)
+	require.Equal(t, []string{"/cosmos.staking.v1beta1.MsgDelegate"}, plan.Unchanged)
+	require.False(t, plan.Empty())
+
+	// an empty desired state resets every circuit
+	plan = PlanCircuits(nil, []string{"/cosmos.gov.v1.MsgVote"})
+	require.Empty(t, plan.Trip)
+	require.Equal(t, []string{"/cosmos.gov.v1.MsgVote"}, plan.Reset)
+
+	plan = PlanCircuits([]string{"/cosmos.gov.v1.MsgVote"}, []string{"/cosmos.gov.v1.MsgVote"})
+	require.True(t, plan.Empty())
+}
+
+func TestSetDesiredStateRequiresUrls(t *testing.T) {
+	// requests are rejected before the breaker client is used
+	api := &API{logger: zap.NewNop(), breakerClient: &breakerclient.BreakerClient{}}
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/v1/circuits/desired", strings.NewReader(body))
+		req = req.WithContext(withIdentity(req.Context(), Identity{Name: "operator", Scopes: []string{SCOPE_CIRCUIT_TRIP, SCOPE_CIRCUIT_RESET}}))
+		rec := httptest.NewRecorder()
+		api.SetDesiredState(rec, req)
+		return rec
+	}
+	rec := send(`{}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Contains(t, rec.Body.String(), "reset_all")
+	rec = send(`{"Message": "incident 42", "Url": ["/cosmos.bank.v1beta1.MsgSend"]}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Contains(t, rec.Body.String(), "unknown field")
+	rec = send(`{"Message": "incident 42", "Urls": []}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,298 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/teamscanworks/breaker/breakerclient"
+	"go.uber.org/zap"
+)
+
+const (
+	// maximum number of drift events kept in memory
+	driftEventRetention = 1000
+	// time after which a change made by this api that was never observed on chain is forgotten
+	driftExpectationTTL = time.Minute * 10
+)
+
+// A change to the circuits tripped on chain which was not made by this api, such as a circuit
+// tripped or reset directly on chain by another account
+type DriftEvent struct {
+	ID  uint64
+	Url string
+	// "trip" if the circuit was tripped, "reset" if it was reset
+	Operation  string
+	DetectedAt time.Time
+	// the change was made after the block at FromHeight, up to and including the block at ToHeight
+	FromHeight int64
+	ToHeight   int64
+}
+
+// Prometheus metrics describing the circuits tripped on chain
+type driftMetrics struct {
+	registry   *prometheus.Registry
+	drift      *prometheus.CounterVec
+	tripped    prometheus.Gauge
+	pollErrors prometheus.Counter
+	lastPoll   prometheus.Gauge
+}
+
+func newDriftMetrics() *driftMetrics {
+	dm := &driftMetrics{
+		registry: prometheus.NewRegistry(),
+		drift: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "breaker_circuit_drift_total",
+			Help: "Number of circuits tripped or reset on chain by something other than this api",
+		}, []string{"operation"}),
+		tripped: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "breaker_circuits_tripped",
+			Help: "Number of circuits currently tripped on chain",
+		}),
+		pollErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "breaker_drift_poll_errors_total",
+			Help: "Number of failed attempts to poll the circuits tripped on chain",
+		}),
+		lastPoll: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "breaker_drift_last_poll_timestamp_seconds",
+			Help: "Unix time of the last successful poll of the circuits tripped on chain",
+		}),
+	}
+	dm.registry.MustRegister(dm.drift, dm.tripped, dm.pollErrors, dm.lastPoll)
+	return dm
+}
+
+// Tracks the circuits tripped on chain, recording changes which were not made by this api
+type driftDetector struct {
+	metrics *driftMetrics
+
+	mu sync.Mutex
+	// circuits tripped as of the last poll, nil until the first poll
+	disabled map[string]bool
+	height   int64
+	// changes made by this api which have not been observed yet, with the time they were made
+	expected map[expectation]time.Time
+	events   []DriftEvent
+	nextID   uint64
+}
+
+func newDriftDetector() *driftDetector {
+	return &driftDetector{
+		metrics:  newDriftMetrics(),
+		expected: make(map[expectation]time.Time),
+		nextID:   1,
+	}
+}
+
+// A change made by this api to the circuit of a url
+type expectation struct {
+	operation Mode
+	url       string
+}
+
+// Records that this api has sent a transaction applying `operation` to `urls`, so the change isn't reported as drift
+func (dd *driftDetector) expect(operation Mode, urls []string) {
+	dd.mu.Lock()
+	defer dd.mu.Unlock()
+	now := time.Now()
+	for _, url := range urls {
+		dd.expected[expectation{operation, url}] = now
+	}
+}
+
+// Removes the expectations recorded by expect, when the transaction could not be sent
+func (dd *driftDetector) forget(operation Mode, urls []string) {
+	dd.mu.Lock()
+	defer dd.mu.Unlock()
+	for _, url := range urls {
+		delete(dd.expected, expectation{operation, url})
+	}
+}
+
+// Forgets the expectations recorded for the transaction `txHash` tripping `trip` and resetting `reset` if it failed,
+// so that a failed transaction doesn't hide the same change made by others. When `result` is nil the transaction
+// was not waited for, or not committed in time, and if drift detection is enabled it is waited for in the background.
+func (api *API) forgetFailedTx(txHash string, result *breakerclient.TxResult, trip []string, reset []string) {
+	if result == nil {
+		if api.driftInterval <= 0 {
+			return
+		}
+		go func() {
+			// the expectations expire if the transaction isn't committed within their ttl
+			result, err := api.breakerClient.WaitForTx(api.ctx, txHash, driftExpectationTTL)
+			if err == nil {
+				api.forgetFailedTx(txHash, result, trip, reset)
+			}
+		}()
+		return
+	}
+	if result.Failed() {
+		api.drift.forget(MODE_TRIP, trip)
+		api.drift.forget(MODE_RESET, reset)
+	}
+}
+
+// Compares the `disabled` urls at `height` with those of the previous poll, returning the changes which
+// were not expected. The first poll records the initial state without reporting any changes.
+func (dd *driftDetector) observe(disabled []string, height int64, now time.Time) []DriftEvent {
+	dd.mu.Lock()
+	defer dd.mu.Unlock()
+	current := make(map[string]bool, len(disabled))
+	for _, url := range disabled {
+		current[url] = true
+	}
+	previous, fromHeight := dd.disabled, dd.height
+	dd.disabled, dd.height = current, height
+	dd.metrics.tripped.Set(float64(len(current)))
+	dd.metrics.lastPoll.Set(float64(now.Unix()))
+	for key, at := range dd.expected {
+		if now.Sub(at) > driftExpectationTTL {
+			delete(dd.expected, key)
+		}
+	}
+	// once the observed state matches the expectations which remain, they can no longer be consumed by a change,
+	// such as when the api tripped a circuit which was already tripped, and would otherwise hide later changes
+	defer dd.dropSatisfied()
+	if previous == nil {
+		return nil
+	}
+
+	var events []DriftEvent
+	record := func(operation Mode, url string) {
+		key := expectation{operation, url}
+		if _, ok := dd.expected[key]; ok {
+			delete(dd.expected, key)
+			return
+		}
+		events = append(events, DriftEvent{
+			Url:        url,
+			Operation:  operation.String(),
+			DetectedAt: now.UTC(),
+			FromHeight: fromHeight,
+			ToHeight:   height,
+		})
+	}
+	for _, url := range sortedKeys(current) {
+		if !previous[url] {
+			record(MODE_TRIP, url)
+		}
+	}
+	for _, url := range sortedKeys(previous) {
+		if !current[url] {
+			record(MODE_RESET, url)
+		}
+	}
+	for i := range events {
+		events[i].ID = dd.nextID
+		dd.nextID++
+		dd.metrics.drift.WithLabelValues(events[i].Operation).Inc()
+	}
+	dd.events = append(dd.events, events...)
+	if len(dd.events) > driftEventRetention {
+		dd.events = append([]DriftEvent(nil), dd.events[len(dd.events)-driftEventRetention:]...)
+	}
+	return events
+}
+
+// Removes expectations which match the circuits tripped as of the last poll. must be called with the lock held
+func (dd *driftDetector) dropSatisfied() {
+	for key := range dd.expected {
+		if (key.operation == MODE_TRIP) == dd.disabled[key.url] {
+			delete(dd.expected, key)
+		}
+	}
+}
+
+// Returns copies of the recorded events detected at or after `since`
+func (dd *driftDetector) list(since time.Time) []DriftEvent {
+	dd.mu.Lock()
+	defer dd.mu.Unlock()
+	events := make([]DriftEvent, 0, len(dd.events))
+	for _, event := range dd.events {
+		if !event.DetectedAt.Before(since) {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Polls the circuits tripped on chain every `interval` until the api is closed
+func (api *API) runDriftDetection(interval time.Duration) {
+	api.checkDrift()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-api.ctx.Done():
+			return
+		case <-ticker.C:
+			api.checkDrift()
+		}
+	}
+}
+
+// Records and reports any changes to the circuits tripped on chain since the last poll which were not made by this api
+func (api *API) checkDrift() {
+	disabled, err := api.breakerClient.ListDisabledCommands(api.ctx)
+	if err != nil {
+		api.drift.metrics.pollErrors.Inc()
+		api.logger.Error("failed to list disabled commands", zap.Error(err))
+		return
+	}
+	// the height is fetched after the disabled commands, so the changes were made at or before it
+	height, err := api.breakerClient.LatestHeight(api.ctx)
+	if err != nil {
+		api.drift.metrics.pollErrors.Inc()
+		api.logger.Error("failed to query latest height", zap.Error(err))
+		return
+	}
+	events := api.drift.observe(disabled.DisabledList, height, time.Now())
+	if len(events) == 0 {
+		return
+	}
+	changes := make([]string, 0, len(events))
+	for _, event := range events {
+		api.logger.Warn("detected circuit change not made by the api",
+			zap.String("url", event.Url),
+			zap.String("operation", event.Operation),
+			zap.Int64("from.height", event.FromHeight),
+			zap.Int64("to.height", event.ToHeight),
+		)
+		changes = append(changes, fmt.Sprintf("%s %s", event.Operation, event.Url))
+	}
+	if api.driftNotifier != nil {
+		text := fmt.Sprintf("circuits changed on chain outside of the breaker api between heights %d and %d: %s", events[0].FromHeight, events[0].ToHeight, strings.Join(changes, ", "))
+		if err := api.driftNotifier.Notify(api.ctx, "drift", text, events); err != nil {
+			api.logger.Error("failed to send drift notification", zap.Error(err))
+		}
+	}
+}
+
+// Returns circuit changes made on chain by something other than this api, optionally filtered
+// by the `since` RFC3339 timestamp query parameter
+func (api *API) ListDriftEvents(w http.ResponseWriter, r *http.Request) {
+	var since time.Time
+	if v := r.URL.Query().Get("since"); v != "" {
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid since %s", err), http.StatusBadRequest)
+			return
+		}
+		since = parsed
+	}
+	events := api.drift.list(since)
+	api.serveJSON(w, r, &events)
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/teamscanworks/breaker/breakerclient"
+	"go.uber.org/zap"
+)
+
+func TestDriftDetector(t *testing.T) {
+	dd := newDriftDetector()
+	now := time.Now()
+	// the first poll records the initial state
+	require.Empty(t, dd.observe([]string{"/cosmos.bank.v1beta1.MsgSend"}, 100, now))
+
+	// changes made by the api are not reported
+	dd.expect(MODE_TRIP, []string{"/cosmos.staking.v1beta1.MsgDelegate"})
+	require.Empty(t, dd.observe([]string{"/cosmos.bank.v1beta1.MsgSend", "/cosmos.staking.v1beta1.MsgDelegate"}, 105, now))
+
+	events := dd.observe([]string{"/cosmos.staking.v1beta1.MsgDelegate", "/cosmos.gov.v1.MsgVote"}, 110, now.Add(time.Minute))
+	require.Len(t, events, 2)
+	require.Equal(t, DriftEvent{
+		ID:         1,
+		Url:        "/cosmos.gov.v1.MsgVote",
+		Operation:  "trip",
+		DetectedAt: now.Add(time.Minute).UTC(),
+		FromHeight: 105,
+		ToHeight:   110,
+	}, events[0])
+	require.Equal(t, "/cosmos.bank.v1beta1.MsgSend", events[1].Url)
+	require.Equal(t, "reset", events[1].Operation)
+
+	// expectations which are forgotten, or never observed, don't hide later changes
+	dd.expect(MODE_RESET, []string{"/cosmos.gov.v1.MsgVote"})
+	dd.forget(MODE_RESET, []string{"/cosmos.gov.v1.MsgVote"})
+	dd.expect(MODE_RESET, []string{"/cosmos.staking.v1beta1.MsgDelegate"})
+	events = dd.observe([]string{"/cosmos.staking.v1beta1.MsgDelegate"}, 115, now.Add(driftExpectationTTL*2))
+	require.Len(t, events, 1)
+	require.Equal(t, "/cosmos.gov.v1.MsgVote", events[0].Url)
+	events = dd.observe(nil, 120, now.Add(driftExpectationTTL*2))
+	require.Len(t, events, 1)
+	require.Equal(t, "/cosmos.staking.v1beta1.MsgDelegate", events[0].Url)
+
+	require.Len(t, dd.list(time.Time{}), 4)
+	require.Len(t, dd.list(now.Add(driftExpectationTTL)), 2)
+}
+
+func TestDriftUnchangedExpectation(t *testing.T) {
+	dd := newDriftDetector()
+	now := time.Now()
+	url := "/cosmos.bank.v1beta1.MsgSend"
+	require.Empty(t, dd.observe([]string{url}, 100, now))
+
+	// tripping a circuit which is already tripped changes nothing, so isn't expected to be observed
+	dd.expect(MODE_TRIP, []string{url})
+	require.Empty(t, dd.observe([]string{url}, 105, now))
+	require.Empty(t, dd.expected)
+
+	// and doesn't hide a reset and trip made by others
+	events := dd.observe(nil, 110, now)
+	require.Len(t, events, 1)
+	require.Equal(t, "reset", events[0].Operation)
+	events = dd.observe([]string{url}, 115, now)
+	require.Len(t, events, 1)
+	require.Equal(t, "trip", events[0].Operation)
+
+	// while expectations which don't match the observed state yet are kept until observed
+	dd.expect(MODE_RESET, []string{url})
+	require.Empty(t, dd.observe([]string{url}, 120, now))
+	require.Len(t, dd.expected, 1)
+	require.Empty(t, dd.observe(nil, 125, now))
+	require.Empty(t, dd.expected)
+}
+
+func TestDriftFailedTx(t *testing.T) {
+	api := &API{logger: zap.NewNop(), drift: newDriftDetector()}
+	now := time.Now()
+	require.Empty(t, api.drift.observe(nil, 100, now))
+
+	// the expectations of a failed transaction are dropped, so the same change made by others is reported
+	api.drift.expect(MODE_TRIP, []string{"/cosmos.bank.v1beta1.MsgSend"})
+	api.drift.expect(MODE_RESET, []string{"/cosmos.gov.v1.MsgVote"})
+	api.forgetFailedTx("ABC", &breakerclient.TxResult{Code: 5}, []string{"/cosmos.bank.v1beta1.MsgSend"}, []string{"/cosmos.gov.v1.MsgVote"})
+	events := api.drift.observe([]string{"/cosmos.bank.v1beta1.MsgSend"}, 105, now)
+	require.Len(t, events, 1)
+	require.Equal(t, "/cosmos.bank.v1beta1.MsgSend", events[0].Url)
+
+	// while those of a successful transaction are kept
+	api.drift.expect(MODE_RESET, []string{"/cosmos.bank.v1beta1.MsgSend"})
+	api.forgetFailedTx("DEF", &breakerclient.TxResult{}, nil, []string{"/cosmos.bank.v1beta1.MsgSend"})
+	require.Empty(t, api.drift.observe(nil, 110, now))
+}
+
+func TestDriftEndpoints(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api, err := NewAPI(ctx, zap.NewNop(), NewJWT("password123", "userId", 3000), ApiOpts{DriftIntervalSeconds: 30}, nil)
+	require.NoError(t, err)
+	server := httptest.NewServer(api.router)
+	defer server.Close()
+
+	api.drift.observe(nil, 1, time.Now())
+	api.drift.observe([]string{"/cosmos.bank.v1beta1.MsgSend"}, 2, time.Now())
+
+	token, err := api.jwt.Encode("monitor", map[string]interface{}{SCOPES_CLAIM: []string{SCOPE_CIRCUIT_READ}})
+	require.NoError(t, err)
+	client := NewAPIClient(server.URL, token)
+	events, err := client.DriftEvents(time.Time{})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, "/cosmos.bank.v1beta1.MsgSend", events[0].Url)
+	events, err = client.DriftEvents(time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Empty(t, events)
+
+	res, err := http.Get(server.URL + "/metrics")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), `breaker_circuit_drift_total{operation="trip"} 1`)
+	require.Contains(t, string(body), "breaker_circuits_tripped 1")
+
+	// metrics are only served when drift detection is enabled
+	disabled, err := NewAPI(ctx, zap.NewNop(), NewJWT("password123", "userId", 3000), ApiOpts{}, nil)
+	require.NoError(t, err)
+	rec := httptest.NewRecorder()
+	disabled.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
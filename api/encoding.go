@@ -0,0 +1,86 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cosmos/cosmos-sdk/codec"
+	"github.com/cosmos/gogoproto/jsonpb"
+	"github.com/cosmos/gogoproto/proto"
+	"go.uber.org/zap"
+)
+
+const (
+	// protojson encoded responses, the default encoding used by the status api
+	CONTENT_TYPE_JSON = "application/json"
+	// binary protobuf encoded responses
+	CONTENT_TYPE_PROTOBUF = "application/x-protobuf"
+)
+
+// A protobuf message type that supports binary serialization, such as the types generated for the x/circuit module
+type protoMessage interface {
+	proto.Message
+	Marshal() ([]byte, error)
+	Unmarshal([]byte) error
+}
+
+// Returns the content type to use for a response based on the request `Accept` header.
+// Media ranges are checked in the order they are given, defaulting to json when no supported
+// content type is requested.
+func negotiateContentType(r *http.Request) string {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case CONTENT_TYPE_PROTOBUF:
+			return CONTENT_TYPE_PROTOBUF
+		case CONTENT_TYPE_JSON:
+			return CONTENT_TYPE_JSON
+		}
+	}
+	return CONTENT_TYPE_JSON
+}
+
+// Serializes `msg` using the content type requested by the client, and writes it to the client.
+func (api *API) serveProto(w http.ResponseWriter, r *http.Request, msg protoMessage) {
+	var (
+		data []byte
+		err  error
+	)
+	contentType := negotiateContentType(r)
+	if contentType == CONTENT_TYPE_PROTOBUF {
+		data, err = msg.Marshal()
+	} else {
+		data, err = codec.ProtoMarshalJSON(msg, nil)
+	}
+	if err != nil {
+		api.logger.Error("failed to marshal response", zap.String("content.type", contentType), zap.Error(err))
+		http.Error(w, "failed to marshal response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, r, "", time.Now(), bytes.NewReader(data))
+}
+
+// Deserializes `data` into `msg` based on the content type returned by the server.
+func decodeProto(contentType string, data []byte, msg protoMessage) error {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("failed to parse content type %s", err)
+	}
+	switch mediaType {
+	case CONTENT_TYPE_PROTOBUF:
+		return msg.Unmarshal(data)
+	case CONTENT_TYPE_JSON:
+		unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+		return unmarshaler.Unmarshal(bytes.NewReader(data), msg)
+	default:
+		return fmt.Errorf("unsupported content type %s", mediaType)
+	}
+}
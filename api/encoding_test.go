@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"cosmossdk.io/x/circuit/types"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestContentNegotiation(t *testing.T) {
+	api := &API{logger: zap.NewNop()}
+	msg := &types.DisabledListResponse{DisabledList: []string{"/cosmos.bank.v1beta1.MsgSend"}}
+	type test struct {
+		name        string
+		accept      string
+		contentType string
+	}
+	tests := []test{
+		{name: "default", accept: "", contentType: CONTENT_TYPE_JSON},
+		{name: "json", accept: "application/json", contentType: CONTENT_TYPE_JSON},
+		{name: "protobuf", accept: "application/x-protobuf", contentType: CONTENT_TYPE_PROTOBUF},
+		{name: "first_supported", accept: "text/html, application/x-protobuf;q=0.9, application/json", contentType: CONTENT_TYPE_PROTOBUF},
+		{name: "unsupported", accept: "text/html", contentType: CONTENT_TYPE_JSON},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/v1/status/list/disabledCommands", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			api.serveProto(rec, req, msg)
+			require.Equal(t, http.StatusOK, rec.Code)
+			require.Equal(t, tt.contentType, rec.Header().Get("Content-Type"))
+			if tt.contentType == CONTENT_TYPE_JSON {
+				require.JSONEq(t, `{"disabled_list":["/cosmos.bank.v1beta1.MsgSend"]}`, rec.Body.String())
+			}
+			var decoded types.DisabledListResponse
+			require.NoError(t, decodeProto(rec.Header().Get("Content-Type"), rec.Body.Bytes(), &decoded))
+			require.Equal(t, msg.DisabledList, decoded.DisabledList)
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// Returns an error if a group has no name or urls
+func validateGroups(groups map[string][]string) error {
+	for name, urls := range groups {
+		if name == "" {
+			return fmt.Errorf("circuit groups must be named")
+		}
+		if len(urls) == 0 {
+			return fmt.Errorf("circuit group %s has no urls", name)
+		}
+	}
+	return nil
+}
+
+// Appends the urls of the named circuit `groups` to `urls`, removing duplicates. The urls of each group
+// are returned keyed by the group name, so callers can report what each group expanded to.
+func (api *API) resolveGroups(urls []string, groups []string) ([]string, map[string][]string, error) {
+	return ResolveGroups(api.groups, urls, groups)
+}
+
+// Appends the urls of the circuit groups named by `groups`, as defined by `defined`, to `urls`, removing
+// duplicates. The urls of each named group are returned keyed by the group name.
+func ResolveGroups(defined map[string][]string, urls []string, groups []string) ([]string, map[string][]string, error) {
+	if len(groups) == 0 {
+		return urls, nil, nil
+	}
+	seen := make(map[string]bool, len(urls))
+	resolved := make([]string, 0, len(urls))
+	add := func(url string) {
+		if !seen[url] {
+			seen[url] = true
+			resolved = append(resolved, url)
+		}
+	}
+	for _, url := range urls {
+		add(url)
+	}
+	expanded := make(map[string][]string, len(groups))
+	for _, name := range groups {
+		groupUrls, ok := defined[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown circuit group %s", name)
+		}
+		expanded[name] = groupUrls
+		for _, url := range groupUrls {
+			add(url)
+		}
+	}
+	return resolved, expanded, nil
+}
+
+// A named list of module request urls, defined in the configuration file
+type CircuitGroup struct {
+	Name string
+	Urls []string
+}
+
+// Returns the configured circuit groups, ordered by name
+func (api *API) ListGroups(w http.ResponseWriter, r *http.Request) {
+	groups := make([]CircuitGroup, 0, len(api.groups))
+	for name, urls := range api.groups {
+		groups = append(groups, CircuitGroup{Name: name, Urls: urls})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	api.serveJSON(w, r, &groups)
+}
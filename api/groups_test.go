@@ -0,0 +1,57 @@
+package api
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestGroups(t *testing.T) {
+	require.ErrorContains(t, validateGroups(map[string][]string{"empty": nil}), "has no urls")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	api, err := NewAPI(ctx, zap.NewNop(), NewJWT("password123", "userId", 3000), ApiOpts{
+		Groups: map[string][]string{
+			"dex-freeze":  {"/osmosis.gamm.v1beta1.MsgSwapExactAmountIn", "/osmosis.gamm.v1beta1.MsgSwapExactAmountOut"},
+			"bank-freeze": {"/cosmos.bank.v1beta1.MsgSend"},
+		},
+	}, nil)
+	require.NoError(t, err)
+
+	urls, groups, err := api.resolveGroups([]string{"/cosmos.bank.v1beta1.MsgSend"}, []string{"bank-freeze", "dex-freeze"})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"/cosmos.bank.v1beta1.MsgSend",
+		"/osmosis.gamm.v1beta1.MsgSwapExactAmountIn",
+		"/osmosis.gamm.v1beta1.MsgSwapExactAmountOut",
+	}, urls)
+	require.Equal(t, []string{"/cosmos.bank.v1beta1.MsgSend"}, groups["bank-freeze"])
+	require.Len(t, groups["dex-freeze"], 2)
+	_, _, err = api.resolveGroups(nil, []string{"missing"})
+	require.ErrorContains(t, err, "unknown circuit group")
+
+	server := httptest.NewServer(api.router)
+	defer server.Close()
+	token, err := api.jwt.Encode("operator", map[string]interface{}{
+		SCOPES_CLAIM: []string{SCOPE_CIRCUIT_TRIP, SCOPE_CIRCUIT_RESET, SCOPE_CIRCUIT_READ},
+	})
+	require.NoError(t, err)
+	client := NewAPIClient(server.URL, token)
+	list, err := client.Groups()
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	require.Equal(t, "bank-freeze", list[0].Name)
+
+	// maintenance windows may reference groups by name
+	start, end := time.Now().Add(time.Hour).UTC(), time.Now().Add(time.Hour*2).UTC()
+	schedule, err := client.CreateSchedule(ScheduleRequest{Groups: []string{"dex-freeze"}, StartTime: &start, EndTime: &end})
+	require.NoError(t, err)
+	require.Len(t, schedule.Urls, 2)
+	_, err = client.CreateSchedule(ScheduleRequest{Groups: []string{"missing"}, StartTime: &start, EndTime: &end})
+	require.ErrorContains(t, err, "400")
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// header containing the hex encoded hmac-sha256 signature, prefixed with "sha256="
+	HMAC_SIGNATURE_HEADER = "X-Breaker-Signature"
+	// header containing the unix timestamp in seconds at which the request was signed
+	HMAC_TIMESTAMP_HEADER = "X-Breaker-Timestamp"
+	// header containing the name of the sender, used to select the shared secret
+	HMAC_SENDER_HEADER = "X-Breaker-Sender"
+	// prefix of the signature header value
+	hmacSignaturePrefix = "sha256="
+)
+
+// A webhook sender which authenticates by signing requests with a shared secret
+type HMACSender struct {
+	// name of the sender, included in logs to identify the caller
+	Name string
+	// shared secret used to sign requests
+	Secret string
+	// scopes granted to the sender
+	Scopes []string
+}
+
+// Returns the hex encoded hmac-sha256 signature of a request, computed over
+// `<method>\n<path>\n<timestamp>\n<body>`. The path includes the query string, if any.
+func SignHMAC(secret string, method string, path string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + path + "\n" + strconv.FormatInt(timestamp, 10) + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sets the hmac headers of `req` for the given sender, signing its method, path and `body` with the current time.
+func SetHMACHeaders(req *http.Request, sender string, secret string, body []byte) {
+	timestamp := time.Now().Unix()
+	req.Header.Set(HMAC_SENDER_HEADER, sender)
+	req.Header.Set(HMAC_TIMESTAMP_HEADER, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HMAC_SIGNATURE_HEADER, hmacSignaturePrefix+SignHMAC(secret, req.Method, req.URL.RequestURI(), timestamp, body))
+}
+
+// Verifies hmac signed requests. The signed timestamp must be within `tolerance` of the current time,
+// and each signature of a request which may change state is only accepted once, preventing previously
+// sent requests from being replayed. Read requests may be repeated, as a client polling the same path
+// more than once a second would otherwise produce identical signatures.
+type hmacVerifier struct {
+	senders   map[string]HMACSender
+	tolerance time.Duration
+
+	mu sync.Mutex
+	// signatures accepted within the tolerance window, and the time at which they expire
+	seen map[string]time.Time
+}
+
+func newHMACVerifier(senders []HMACSender, tolerance time.Duration) *hmacVerifier {
+	hv := &hmacVerifier{
+		senders:   make(map[string]HMACSender, len(senders)),
+		tolerance: tolerance,
+		seen:      make(map[string]time.Time),
+	}
+	for _, sender := range senders {
+		hv.senders[sender.Name] = sender
+	}
+	return hv
+}
+
+// Returns true if the request includes a hmac signature
+func isHMACRequest(r *http.Request) bool {
+	return r.Header.Get(HMAC_SIGNATURE_HEADER) != ""
+}
+
+// Verifies the signature of the request, returning the sender. The request body
+// is read, and replaced so that it can be read again by the next handler.
+func (hv *hmacVerifier) verify(r *http.Request) (*HMACSender, error) {
+	sender, ok := hv.senders[r.Header.Get(HMAC_SENDER_HEADER)]
+	if !ok {
+		return nil, fmt.Errorf("unknown hmac sender")
+	}
+	timestamp, err := strconv.ParseInt(r.Header.Get(HMAC_TIMESTAMP_HEADER), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hmac timestamp")
+	}
+	now := time.Now()
+	signedAt := time.Unix(timestamp, 0)
+	if signedAt.Before(now.Add(-hv.tolerance)) || signedAt.After(now.Add(hv.tolerance)) {
+		return nil, fmt.Errorf("hmac timestamp outside of tolerance")
+	}
+	signature, err := hex.DecodeString(strings.TrimPrefix(r.Header.Get(HMAC_SIGNATURE_HEADER), hmacSignaturePrefix))
+	if err != nil {
+		return nil, fmt.Errorf("invalid hmac signature encoding")
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body")
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	expected, _ := hex.DecodeString(SignHMAC(sender.Secret, r.Method, r.URL.RequestURI(), timestamp, body))
+	if !hmac.Equal(signature, expected) {
+		return nil, fmt.Errorf("invalid hmac signature")
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return &sender, nil
+	}
+
+	hv.mu.Lock()
+	defer hv.mu.Unlock()
+	for sig, expiresAt := range hv.seen {
+		if now.After(expiresAt) {
+			delete(hv.seen, sig)
+		}
+	}
+	key := hex.EncodeToString(signature)
+	if _, ok := hv.seen[key]; ok {
+		return nil, fmt.Errorf("hmac signature has already been used")
+	}
+	hv.seen[key] = signedAt.Add(hv.tolerance)
+	return &sender, nil
+}
+
+// Authenticates requests with a hmac signature when hmac senders are configured, otherwise
+// falls back to verifying the jwt included in the request.
+func (api *API) Authenticator(next http.Handler) http.Handler {
+	jwtAuthenticator := api.jwt.Verifier(api.jwt.Authenticator(next))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if api.hmac == nil || !isHMACRequest(r) {
+			jwtAuthenticator.ServeHTTP(w, r)
+			return
+		}
+		sender, err := api.hmac.verify(r)
+		if err != nil {
+			api.logger.Warn("failed to verify hmac signature", zap.String("hmac.sender", r.Header.Get(HMAC_SENDER_HEADER)), zap.Error(err))
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		api.logger.Info("verified hmac signature", zap.String("hmac.sender", sender.Name), zap.String("path", r.URL.Path))
+		identity := Identity{
+			Name:   sender.Name,
+			Method: AUTH_METHOD_HMAC,
+			Scopes: sender.Scopes,
+		}
+		next.ServeHTTP(w, r.WithContext(withIdentity(r.Context(), identity)))
+	})
+}
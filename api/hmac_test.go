@@ -0,0 +1,97 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestHMACAuthenticator(t *testing.T) {
+	api := &API{
+		logger: zap.NewNop(),
+		jwt:    NewJWT("password123", "userId", 300),
+		hmac:   newHMACVerifier([]HMACSender{{Name: "monitoring", Secret: "secret123", Scopes: []string{SCOPE_CIRCUIT_TRIP}}}, time.Minute),
+	}
+	var identity Identity
+	handler := api.Authenticator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity = IdentityFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+	body := []byte(`{"Urls":["/cosmos.bank.v1beta1.MsgSend"],"Operation":0}`)
+	newRequest := func(sender string, secret string, timestamp int64) *http.Request {
+		req := httptest.NewRequest("POST", "/v1/webhook", bytes.NewReader(body))
+		req.Header.Set(HMAC_SENDER_HEADER, sender)
+		req.Header.Set(HMAC_TIMESTAMP_HEADER, strconv.FormatInt(timestamp, 10))
+		req.Header.Set(HMAC_SIGNATURE_HEADER, hmacSignaturePrefix+SignHMAC(secret, "POST", "/v1/webhook", timestamp, body))
+		return req
+	}
+	serve := func(req *http.Request) int {
+		identity = Identity{}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		req := newRequest("monitoring", "secret123", time.Now().Unix())
+		require.Equal(t, http.StatusOK, serve(req))
+		require.Equal(t, Identity{Name: "monitoring", Method: AUTH_METHOD_HMAC, Scopes: []string{SCOPE_CIRCUIT_TRIP}}, identity)
+	})
+	t.Run("replay", func(t *testing.T) {
+		req := newRequest("monitoring", "secret123", time.Now().Unix()-1)
+		require.Equal(t, http.StatusOK, serve(req))
+		req = newRequest("monitoring", "secret123", time.Now().Unix()-1)
+		require.Equal(t, http.StatusUnauthorized, serve(req))
+	})
+	t.Run("other_endpoint", func(t *testing.T) {
+		// a signature captured for one endpoint can't be sent to another, or with another method
+		signed := newRequest("monitoring", "secret123", time.Now().Unix()-2)
+		for _, target := range []struct{ method, path string }{{"POST", "/v1/circuits/desired"}, {"PUT", "/v1/webhook"}} {
+			req := httptest.NewRequest(target.method, target.path, bytes.NewReader(body))
+			req.Header = signed.Header.Clone()
+			require.Equal(t, http.StatusUnauthorized, serve(req))
+		}
+	})
+	t.Run("repeated_reads", func(t *testing.T) {
+		// bodyless reads signed in the same second are accepted, whether or not they share a path
+		timestamp := time.Now().Unix()
+		for _, path := range []string{"/v1/status/list", "/v1/status/accounts", "/v1/status/list"} {
+			req := httptest.NewRequest("GET", path, nil)
+			req.Header.Set(HMAC_SENDER_HEADER, "monitoring")
+			req.Header.Set(HMAC_TIMESTAMP_HEADER, strconv.FormatInt(timestamp, 10))
+			req.Header.Set(HMAC_SIGNATURE_HEADER, hmacSignaturePrefix+SignHMAC("secret123", "GET", path, timestamp, nil))
+			require.Equal(t, http.StatusOK, serve(req))
+		}
+	})
+	t.Run("wrong_secret", func(t *testing.T) {
+		req := newRequest("monitoring", "foobar", time.Now().Unix())
+		require.Equal(t, http.StatusUnauthorized, serve(req))
+	})
+	t.Run("unknown_sender", func(t *testing.T) {
+		req := newRequest("ci", "secret123", time.Now().Unix())
+		require.Equal(t, http.StatusUnauthorized, serve(req))
+	})
+	t.Run("expired_timestamp", func(t *testing.T) {
+		req := newRequest("monitoring", "secret123", time.Now().Add(-time.Minute*5).Unix())
+		require.Equal(t, http.StatusUnauthorized, serve(req))
+	})
+	t.Run("jwt_fallback", func(t *testing.T) {
+		token, err := api.jwt.Encode("apiTest", map[string]interface{}{SCOPES_CLAIM: []string{SCOPE_CIRCUIT_RESET}})
+		require.NoError(t, err)
+		req := httptest.NewRequest("POST", "/v1/webhook", bytes.NewReader(body))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		require.Equal(t, http.StatusOK, serve(req))
+		require.Equal(t, Identity{Name: "apiTest", Method: AUTH_METHOD_JWT, Scopes: []string{SCOPE_CIRCUIT_RESET}}, identity)
+	})
+	t.Run("no_credentials", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/v1/webhook", bytes.NewReader(body))
+		require.Equal(t, http.StatusUnauthorized, serve(req))
+	})
+}
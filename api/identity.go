@@ -0,0 +1,137 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	// caller authenticated with a jwt
+	AUTH_METHOD_JWT = "jwt"
+	// caller authenticated with a hmac signature
+	AUTH_METHOD_HMAC = "hmac"
+)
+
+const (
+	// jwt claim containing the scopes granted to the token, either as a list or a space separated string
+	SCOPES_CLAIM = "scopes"
+	// jwt claim granting account management to tokens issued before scopes were enforced
+	LEGACY_ADMIN_CLAIM = "admin"
+	// allows tripping circuits
+	SCOPE_CIRCUIT_TRIP = "circuit:trip"
+	// allows resetting circuits
+	SCOPE_CIRCUIT_RESET = "circuit:reset"
+	// allows reading the state of asynchronous jobs, and the audit log
+	SCOPE_CIRCUIT_READ = "circuit:read"
+	// allows authorizing and revoking accounts
+	SCOPE_ACCOUNTS_ADMIN = "accounts:admin"
+)
+
+var (
+	// all scopes which may be granted to a caller
+	SupportedScopes = []string{
+		SCOPE_CIRCUIT_TRIP,
+		SCOPE_CIRCUIT_RESET,
+		SCOPE_CIRCUIT_READ,
+		SCOPE_ACCOUNTS_ADMIN,
+	}
+)
+
+type identityContextKey struct{}
+
+// The authenticated caller of an api request
+type Identity struct {
+	// name of the caller, which is the jwt identifier field value, or the hmac sender name
+	Name string
+	// method used to authenticate the caller, one of AUTH_METHOD_JWT or AUTH_METHOD_HMAC
+	Method string
+	// scopes granted to the caller
+	Scopes []string
+}
+
+// Returns true if the caller has been granted `scope`
+func (i Identity) HasScope(scope string) bool {
+	for _, s := range i.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the scope required to apply the given operation
+func RequiredScope(operation Mode) string {
+	if operation == MODE_RESET {
+		return SCOPE_CIRCUIT_RESET
+	}
+	return SCOPE_CIRCUIT_TRIP
+}
+
+// Returns an error if any of the given scopes are not supported
+func ValidateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		supported := false
+		for _, s := range SupportedScopes {
+			if s == scope {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return fmt.Errorf("unsupported scope %s, must be one of %s", scope, strings.Join(SupportedScopes, ", "))
+		}
+	}
+	return nil
+}
+
+// Parses the scopes claim of a token, which may be a list of strings or a space separated string
+func parseScopesClaim(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return strings.Fields(v)
+	case []string:
+		return v
+	case []interface{}:
+		scopes := make([]string, 0, len(v))
+		for _, s := range v {
+			if scope, ok := s.(string); ok {
+				scopes = append(scopes, scope)
+			}
+		}
+		return scopes
+	default:
+		return nil
+	}
+}
+
+// Returns a copy of ctx containing the given identity
+func withIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityContextKey{}, identity)
+}
+
+// Returns the identity of the authenticated caller, or an empty identity for unauthenticated requests
+func IdentityFromContext(ctx context.Context) Identity {
+	identity, _ := ctx.Value(identityContextKey{}).(Identity)
+	return identity
+}
+
+// Writes a 403 Forbidden response naming the missing scope
+func forbidScope(w http.ResponseWriter, scope string) {
+	http.Error(w, fmt.Sprintf("missing required scope %s", scope), http.StatusForbidden)
+}
+
+// Returns a middleware which must be chained after API.Authenticator, rejecting callers that have
+// not been granted `scope` with a 403 Forbidden response.
+func RequireScope(scope string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !IdentityFromContext(r.Context()).HasScope(scope) {
+				forbidScope(w, scope)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScopes(t *testing.T) {
+	t.Run("parse_claim", func(t *testing.T) {
+		require.Equal(t, []string{SCOPE_CIRCUIT_TRIP, SCOPE_CIRCUIT_READ}, parseScopesClaim("circuit:trip circuit:read"))
+		require.Equal(t, []string{SCOPE_CIRCUIT_TRIP}, parseScopesClaim([]interface{}{"circuit:trip", 1}))
+		require.Nil(t, parseScopesClaim(nil))
+	})
+	t.Run("validate", func(t *testing.T) {
+		require.NoError(t, ValidateScopes(SupportedScopes))
+		require.Error(t, ValidateScopes([]string{"circuit:foobar"}))
+	})
+	t.Run("required_scope", func(t *testing.T) {
+		require.Equal(t, SCOPE_CIRCUIT_TRIP, RequiredScope(MODE_TRIP))
+		require.Equal(t, SCOPE_CIRCUIT_RESET, RequiredScope(MODE_RESET))
+	})
+	t.Run("require_scope", func(t *testing.T) {
+		handler := RequireScope(SCOPE_ACCOUNTS_ADMIN)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		req := httptest.NewRequest("POST", "/v1/accounts/cosmos1/authorize", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req.WithContext(withIdentity(req.Context(), Identity{Scopes: []string{SCOPE_CIRCUIT_TRIP}})))
+		require.Equal(t, http.StatusForbidden, rec.Code)
+		require.Contains(t, rec.Body.String(), "missing required scope accounts:admin")
+
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, req.WithContext(withIdentity(req.Context(), Identity{Scopes: []string{SCOPE_ACCOUNTS_ADMIN}})))
+		require.Equal(t, http.StatusOK, rec.Code)
+	})
+}
+
+func TestLegacyScopelessTokens(t *testing.T) {
+	jt := NewJWT("password123", "userId", 300)
+	var identity Identity
+	handler := jt.Verifier(jt.Authenticator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity = IdentityFromContext(r.Context())
+	})))
+	authenticate := func(claims map[string]interface{}) Identity {
+		token, err := jt.Encode("apiTest", claims)
+		require.NoError(t, err)
+		req := httptest.NewRequest("POST", "/v1/webhook", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		identity = Identity{}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		return identity
+	}
+
+	// tokens without a scopes claim are granted nothing unless legacy tokens are enabled
+	require.Empty(t, authenticate(nil).Scopes)
+	jt.SetLegacyScopelessTokens(true)
+	require.Equal(t, []string{SCOPE_CIRCUIT_TRIP, SCOPE_CIRCUIT_RESET, SCOPE_CIRCUIT_READ}, authenticate(nil).Scopes)
+	require.Equal(t, SupportedScopes, authenticate(map[string]interface{}{LEGACY_ADMIN_CLAIM: true}).Scopes)
+	// tokens with a scopes claim are unaffected, even if empty
+	require.Equal(t, []string{SCOPE_CIRCUIT_TRIP}, authenticate(map[string]interface{}{SCOPES_CLAIM: []string{SCOPE_CIRCUIT_TRIP}}).Scopes)
+	require.Empty(t, authenticate(map[string]interface{}{SCOPES_CLAIM: []string{}, LEGACY_ADMIN_CLAIM: true}).Scopes)
+}
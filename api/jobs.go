@@ -0,0 +1,186 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
+)
+
+const (
+	// time for which finished jobs can be queried before being removed
+	jobRetention = time.Hour
+	// maximum number of jobs that may be waiting for execution
+	jobQueueSize = 100
+)
+
+// Typed string representing the state of an asynchronous webhook job
+type JobStatus string
+
+const (
+	// job has been accepted and is waiting to be executed
+	JOB_QUEUED JobStatus = "queued"
+	// transaction has been broadcast, and is waiting to be committed
+	JOB_BROADCAST JobStatus = "broadcast"
+	// transaction has been committed and executed successfully
+	JOB_COMMITTED JobStatus = "committed"
+	// job failed to broadcast, or the transaction failed during execution
+	JOB_FAILED JobStatus = "failed"
+	// transaction was simulated successfully instead of being broadcast
+	JOB_SIMULATED JobStatus = "simulated"
+)
+
+// An asynchronous webhook call, created by sending a payload to the webhook api with `?async=true`
+type Job struct {
+	ID     string
+	Status JobStatus
+	// transaction hash, set once the transaction has been broadcast
+	TxHash    string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// result of the webhook call, set once the job is committed or failed
+	Response *Response
+}
+
+// Returns true if the job is committed, failed or simulated
+func (j *Job) Finished() bool {
+	return j.Status == JOB_COMMITTED || j.Status == JOB_FAILED || j.Status == JOB_SIMULATED
+}
+
+// In memory store of asynchronous jobs, executed in the order they are submitted
+type jobStore struct {
+	mu    sync.RWMutex
+	jobs  map[string]*Job
+	queue chan queuedJob
+}
+
+type queuedJob struct {
+	id      string
+	payload PayloadV1
+	// caller which submitted the job
+	identity Identity
+	// id of the request which submitted the job
+	requestID string
+	// audit entry recorded when the job was submitted
+	intake auditIntake
+}
+
+func newJobStore() *jobStore {
+	return &jobStore{
+		jobs:  make(map[string]*Job),
+		queue: make(chan queuedJob, jobQueueSize),
+	}
+}
+
+// Queues the payload submitted by `identity` for execution, returning a copy of the created job. If not nil, `record`
+// is called with the job once it is certain to be queued, and returns the audit intake of the request which the
+// result of the job is appended to.
+func (js *jobStore) submit(payload PayloadV1, identity Identity, requestID string, record func(job Job) auditIntake) (Job, error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return Job{}, fmt.Errorf("failed to generate job id %s", err)
+	}
+	now := time.Now().UTC()
+	job := &Job{
+		ID:        hex.EncodeToString(idBytes),
+		Status:    JOB_QUEUED,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.prune(now)
+	// jobs are only queued with the lock held, so the send below can't block once the queue has capacity
+	if len(js.queue) >= cap(js.queue) {
+		return Job{}, fmt.Errorf("job queue is full")
+	}
+	queued := queuedJob{id: job.ID, payload: payload, identity: identity, requestID: requestID}
+	if record != nil {
+		queued.intake = record(*job)
+	}
+	js.queue <- queued
+	js.jobs[job.ID] = job
+	return *job, nil
+}
+
+// Returns a copy of the job with the given id
+func (js *jobStore) get(id string) (Job, bool) {
+	js.mu.RLock()
+	defer js.mu.RUnlock()
+	job, ok := js.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// Applies `fn` against the job with the given id, updating its modification time
+func (js *jobStore) update(id string, fn func(job *Job)) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if job, ok := js.jobs[id]; ok {
+		fn(job)
+		job.UpdatedAt = time.Now().UTC()
+	}
+}
+
+// removes finished jobs older than the retention period, must be called with the lock held
+func (js *jobStore) prune(now time.Time) {
+	for id, job := range js.jobs {
+		if job.Finished() && now.Sub(job.UpdatedAt) > jobRetention {
+			delete(js.jobs, id)
+		}
+	}
+}
+
+// Executes queued jobs until the api is closed. Jobs are executed using the api context
+// rather than the request context, so they are unaffected by client disconnects.
+func (api *API) runJobs() {
+	for {
+		select {
+		case <-api.ctx.Done():
+			return
+		case queued := <-api.jobs.queue:
+			api.logger.Info("executing job", zap.String("job.id", queued.id))
+			// async jobs always wait for the transaction to be committed in order to report the final status
+			queued.payload.WaitForCommit = true
+			ctx := withIdentity(api.ctx, queued.identity)
+			ctx = withJobID(context.WithValue(ctx, middleware.RequestIDKey, queued.requestID), queued.id)
+			ctx = withAuditIntake(ctx, queued.intake)
+			response := api.executePayload(ctx, queued.payload, func(txHash string) {
+				api.jobs.update(queued.id, func(job *Job) {
+					job.Status = JOB_BROADCAST
+					job.TxHash = txHash
+				})
+			})
+			api.jobs.update(queued.id, func(job *Job) {
+				if response.Message == "ok" && response.DryRun {
+					job.Status = JOB_SIMULATED
+				} else if response.Message == "ok" {
+					job.Status = JOB_COMMITTED
+				} else {
+					job.Status = JOB_FAILED
+				}
+				job.Response = &response
+			})
+			api.logger.Info("finished job", zap.String("job.id", queued.id), zap.String("message", response.Message))
+		}
+	}
+}
+
+// Returns the job given by the `id` url parameter, sending a 404 Not Found response if no such job exists
+func (api *API) GetJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := api.jobs.get(chi.URLParam(r, "id"))
+	if !ok {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	api.serveJSON(w, r, &job)
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobStore(t *testing.T) {
+	js := newJobStore()
+	job, err := js.submit(PayloadV1{Urls: []string{"/cosmos.bank.v1beta1.MsgSend"}, Operation: MODE_TRIP}, Identity{Name: "apiTest"}, "request-1", nil)
+	require.NoError(t, err)
+	require.Equal(t, JOB_QUEUED, job.Status)
+	require.Len(t, job.ID, 32)
+
+	queued := <-js.queue
+	require.Equal(t, job.ID, queued.id)
+	require.Equal(t, []string{"/cosmos.bank.v1beta1.MsgSend"}, queued.payload.Urls)
+	require.Equal(t, "apiTest", queued.identity.Name)
+
+	js.update(job.ID, func(j *Job) {
+		j.Status = JOB_BROADCAST
+		j.TxHash = "ABCD"
+	})
+	got, ok := js.get(job.ID)
+	require.True(t, ok)
+	require.Equal(t, JOB_BROADCAST, got.Status)
+	require.Equal(t, "ABCD", got.TxHash)
+	require.False(t, got.Finished())
+
+	js.update(job.ID, func(j *Job) {
+		j.Status = JOB_COMMITTED
+		j.Response = &Response{Message: "ok"}
+	})
+	got, ok = js.get(job.ID)
+	require.True(t, ok)
+	require.True(t, got.Finished())
+	require.True(t, (&Job{Status: JOB_SIMULATED}).Finished())
+
+	// finished jobs are removed once the retention period has elapsed
+	js.mu.Lock()
+	js.prune(time.Now().Add(jobRetention * 2))
+	js.mu.Unlock()
+	_, ok = js.get(job.ID)
+	require.False(t, ok)
+
+	_, ok = js.get("missing")
+	require.False(t, ok)
+}
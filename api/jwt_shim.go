@@ -2,19 +2,43 @@ package api
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
 	"fmt"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/go-chi/jwtauth/v5"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
 	"github.com/lestrrat-go/jwx/v2/jwt"
 )
 
-// wraps jwtauth.JWTAuth with helper functions to ease usage of JWTs for API authentication
+// helper functions to ease usage of JWTs for API authentication
 type JWT struct {
-	tokenAuth           *jwtauth.JWTAuth
-	identifierField     string
-	validityDurationSec int64
+	alg jwa.SignatureAlgorithm
+	// key used to sign issued tokens, nil if the JWT may only be used to verify tokens
+	signKey interface{}
+	// verifies the signature of parsed tokens
+	verifier jwt.ParseOption
+	// when set, tokens are verified against the key set of an oidc provider instead of verifier
+	jwks *jwksKeys
+	// additional validation applied to the claims of verified tokens
+	validateOpts []jwt.ValidateOption
+	// claim containing the scopes granted to the token
+	scopesClaim string
+	// when set, issued tokens are recorded and revoked tokens are rejected
+	tokens *TokenStore
+	// when set, tokens without a scopes claim are granted the access they had before scopes were enforced
+	legacyScopelessTokens bool
+	identifierField       string
+	validityDurationSec   int64
 }
 
 // Initializes a new JWT object, with the signature algorith m HS256 encrypted with the given `password`.
@@ -24,42 +48,252 @@ func NewJWT(password string, identifierField string, tokenValidityDurationSec in
 	return NewJWTWithSignature(password, identifierField, "HS256", tokenValidityDurationSec)
 }
 
-// Like NewJWT but allows control of the signature algorithm.
+// Like NewJWT but allows control of the signature algorithm, which must be one of HS256, HS384 or HS512.
+// Asymmetric algorithms must use NewJWTFromKeyFiles.
 func NewJWTWithSignature(password string, identifierField string, signature string, tokenValidityDurationSec int64) *JWT {
+	alg := jwa.SignatureAlgorithm(signature)
 	return &JWT{
+		alg:                 alg,
+		signKey:             []byte(password),
+		verifier:            jwt.WithKey(alg, []byte(password)),
+		scopesClaim:         SCOPES_CLAIM,
 		identifierField:     identifierField,
-		tokenAuth:           jwtauth.New(signature, []byte(password), nil),
 		validityDurationSec: tokenValidityDurationSec,
 	}
 }
 
-// Issues a new jwt adding the given identifier and extra fields to the claims.
-func (jwt *JWT) Encode(identifier string, extraFields map[string]interface{}) (string, error) {
+// Initializes a new JWT object using an asymmetric signature algorithm (RS256, PS256, ES256, EdDSA, etc..)
+// with PEM encoded keys loaded from disk.
+//
+// `privateKeyFile` is used to sign issued tokens, and may be left empty so that the JWT is only able to verify tokens.
+// Tokens are verified against any of the keys in `publicKeyFiles`, allowing keys to be rotated. When no public key
+// files are given, tokens are verified against the public key of `privateKeyFile`.
+func NewJWTFromKeyFiles(
+	signature string,
+	privateKeyFile string,
+	publicKeyFiles []string,
+	identifierField string,
+	tokenValidityDurationSec int64,
+) (*JWT, error) {
+	alg := jwa.SignatureAlgorithm(signature)
+	if isHMACAlgorithm(alg) {
+		return nil, fmt.Errorf("signature algorithm %s requires a password instead of key files", signature)
+	}
+	if privateKeyFile == "" && len(publicKeyFiles) == 0 {
+		return nil, fmt.Errorf("signature algorithm %s requires a private key file or public key files", signature)
+	}
+	jt := &JWT{
+		alg:                 alg,
+		scopesClaim:         SCOPES_CLAIM,
+		identifierField:     identifierField,
+		validityDurationSec: tokenValidityDurationSec,
+	}
+	publicKeys := jwk.NewSet()
+	if privateKeyFile != "" {
+		signKey, err := loadKeyFile(privateKeyFile, alg)
+		if err != nil {
+			return nil, err
+		}
+		if !isPrivateKey(signKey) {
+			return nil, fmt.Errorf("key file %s does not contain a private key", privateKeyFile)
+		}
+		jt.signKey = signKey
+		if len(publicKeyFiles) == 0 {
+			publicKey, err := signKey.PublicKey()
+			if err != nil {
+				return nil, fmt.Errorf("failed to derive public key %s", err)
+			}
+			publicKeys.AddKey(publicKey)
+		}
+	}
+	for _, publicKeyFile := range publicKeyFiles {
+		key, err := loadKeyFile(publicKeyFile, alg)
+		if err != nil {
+			return nil, err
+		}
+		// only the public portion of the key is used for verification
+		publicKey, err := key.PublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive public key from %s %s", publicKeyFile, err)
+		}
+		publicKeys.AddKey(publicKey)
+	}
+	// tokens are signed with a key id, which is used to select the public key. tokens without a key id are
+	// verified against every public key
+	jt.verifier = jwt.WithKeySet(publicKeys, jws.WithRequireKid(false))
+	return jt, nil
+}
+
+// Loads a PEM encoded key from `path`, setting its algorithm and assigning a key id derived from its thumbprint.
+func loadKeyFile(path string, alg jwa.SignatureAlgorithm) (jwk.Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file %s", err)
+	}
+	key, err := jwk.ParseKey(data, jwk.WithPEM(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key file %s %s", path, err)
+	}
+	if err := key.Set(jwk.AlgorithmKey, alg); err != nil {
+		return nil, fmt.Errorf("failed to set key algorithm %s", err)
+	}
+	// the thumbprint of a private key matches that of its public key
+	if err := jwk.AssignKeyID(key); err != nil {
+		return nil, fmt.Errorf("failed to assign key id %s", err)
+	}
+	return key, nil
+}
+
+// Returns true if the key contains private key material
+func isPrivateKey(key jwk.Key) bool {
+	var raw interface{}
+	if err := key.Raw(&raw); err != nil {
+		return false
+	}
+	switch raw.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+		return true
+	default:
+		return false
+	}
+}
+
+// Returns true if the algorithm is a symmetric hmac based algorithm
+func isHMACAlgorithm(alg jwa.SignatureAlgorithm) bool {
+	return strings.HasPrefix(alg.String(), "HS")
+}
+
+// Returns true if the JWT has a key to sign issued tokens, which is not the case when verifying tokens
+// issued by an oidc provider, or when only public key files are configured.
+func (jt *JWT) CanSign() bool {
+	return jt.signKey != nil
+}
+
+// Sets the store used to record issued tokens, and to reject tokens which have been revoked.
+func (jt *JWT) SetTokenStore(tokens *TokenStore) {
+	jt.tokens = tokens
+}
+
+// When enabled, tokens issued without a scopes claim before scopes were enforced are granted the access they
+// previously had, see legacyScopes. Intended as a transition period while such tokens are reissued with scopes.
+func (jt *JWT) SetLegacyScopelessTokens(enabled bool) {
+	jt.legacyScopelessTokens = enabled
+}
+
+// Returns true if tokens without a scopes claim are granted their legacy access
+func (jt *JWT) LegacyScopelessTokens() bool {
+	return jt.legacyScopelessTokens
+}
+
+// Returns the scopes matching the access of tokens issued before scopes were enforced, which could trip and reset
+// circuits and poll jobs, and manage accounts if issued with LEGACY_ADMIN_CLAIM set to true.
+func legacyScopes(token jwt.Token) []string {
+	scopes := []string{SCOPE_CIRCUIT_TRIP, SCOPE_CIRCUIT_RESET, SCOPE_CIRCUIT_READ}
+	if v, ok := token.Get(LEGACY_ADMIN_CLAIM); ok {
+		if isAdmin, ok := v.(bool); ok && isAdmin {
+			scopes = append(scopes, SCOPE_ACCOUNTS_ADMIN)
+		}
+	}
+	return scopes
+}
+
+// Issues a new jwt adding the given identifier and extra fields to the claims. The scopes granted
+// to the token are set by including SCOPES_CLAIM in the extra fields. Each token is given a unique
+// jti claim, allowing it to be revoked, and is recorded in the token store if one is set.
+func (jt *JWT) Encode(identifier string, extraFields map[string]interface{}) (string, error) {
+	encoded, _, err := jt.encode(identifier, extraFields, jt.validityDurationSec, true)
+	return encoded, err
+}
+
+// Issues a new jwt valid for `validityDurationSec` seconds, returning the encoded and parsed token. The token
+// is recorded in the token store if one is set and `record` is true.
+func (jt *JWT) encode(identifier string, extraFields map[string]interface{}, validityDurationSec int64, record bool) (string, jwt.Token, error) {
+	if jt.signKey == nil {
+		return "", nil, fmt.Errorf("failed to encode jwt: no private key configured")
+	}
 	if extraFields == nil {
 		extraFields = make(map[string]interface{}, 3)
 	}
-	expiresAt := time.Duration(time.Now().Unix() + (int64(time.Second) * jwt.validityDurationSec))
+	expiresAt := time.Duration(time.Now().Unix() + (int64(time.Second) * validityDurationSec))
 	if identifier != "" {
-		extraFields[jwt.identifierField] = identifier
+		extraFields[jt.identifierField] = identifier
 	}
 	jwtauth.SetIssuedNow(extraFields)
 	jwtauth.SetExpiryIn(extraFields, expiresAt)
+	jtiBytes := make([]byte, 16)
+	if _, err := rand.Read(jtiBytes); err != nil {
+		return "", nil, fmt.Errorf("failed to generate jti: %s", err)
+	}
+	extraFields[jwt.JwtIDKey] = hex.EncodeToString(jtiBytes)
 
-	_, encoded, err := jwt.tokenAuth.Encode(extraFields)
+	token := jwt.New()
+	for k, v := range extraFields {
+		if err := token.Set(k, v); err != nil {
+			return "", nil, fmt.Errorf("failed to encode jwt: %s", err)
+		}
+	}
+	encoded, err := jwt.Sign(token, jwt.WithKey(jt.alg, jt.signKey))
 	if err != nil {
-		return "", fmt.Errorf("failed to encode jwt: %s", err)
+		return "", nil, fmt.Errorf("failed to encode jwt: %s", err)
+	}
+	if jt.tokens != nil && record {
+		issued := IssuedToken{
+			JTI:       token.JwtID(),
+			Scopes:    parseScopesClaim(extraFields[SCOPES_CLAIM]),
+			IssuedAt:  token.IssuedAt().UTC(),
+			ExpiresAt: token.Expiration().UTC(),
+		}
+		if identifier != "" {
+			issued.Identifier = identifier
+		}
+		if err := jt.tokens.Record(issued); err != nil {
+			return "", nil, fmt.Errorf("failed to record jwt: %s", err)
+		}
 	}
-	return encoded, nil
+	return string(encoded), token, nil
 }
 
 // Parses an encoded jwt token it's a jwt.Token type.
-func (jwt *JWT) Decode(ctx context.Context, token string) (jwt.Token, error) {
-	return jwt.tokenAuth.Decode(token)
+func (jt *JWT) Decode(ctx context.Context, token string) (jwt.Token, error) {
+	if jt.jwks != nil {
+		return jt.jwks.parse(ctx, token)
+	}
+	// validation is disabled here because jwt.Validate is used by CheckToken
+	return jwt.Parse([]byte(token), jt.verifier, jwt.WithValidate(false))
+}
+
+// Verifier http middleware searches for a jwt in the `Authorization: BEARER T` request header, followed
+// by the `jwt` cookie, and verifies it. The token and any verification error are set on the request
+// context, and can be retrieved with jwtauth.FromContext.
+func (jt *JWT) Verifier(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := jt.verifyRequest(r)
+		next.ServeHTTP(w, r.WithContext(jwtauth.NewContext(r.Context(), token, err)))
+	})
 }
 
-// Used to perform validation of the jwt token, and identifier fields if required.
+func (jt *JWT) verifyRequest(r *http.Request) (jwt.Token, error) {
+	tokenString := jwtauth.TokenFromHeader(r)
+	if tokenString == "" {
+		tokenString = jwtauth.TokenFromCookie(r)
+	}
+	if tokenString == "" {
+		return nil, jwtauth.ErrNoTokenFound
+	}
+	token, err := jt.Decode(r.Context(), tokenString)
+	if err != nil {
+		return token, jwtauth.ErrorReason(err)
+	}
+	if err := jwt.Validate(token, jt.validateOpts...); err != nil {
+		return token, jwtauth.ErrorReason(err)
+	}
+	return token, nil
+}
+
+// Used to perform validation of the jwt token, and identifier fields if required. Tokens
+// revoked in the token store are rejected, as are tokens refreshed from a revoked token.
 func (jt *JWT) CheckToken(token jwt.Token) error {
-	if token == nil || jwt.Validate(token) != nil {
+	if token == nil || jwt.Validate(token, jt.validateOpts...) != nil {
 		return fmt.Errorf("failed to validate token")
 	}
 	if jt.identifierField != "" {
@@ -71,6 +305,12 @@ func (jt *JWT) CheckToken(token jwt.Token) error {
 			return fmt.Errorf("failed to parse token map for field %s", jt.identifierField)
 		}
 	}
+	if jt.tokens != nil && token.JwtID() != "" && jt.tokens.IsRevoked(token.JwtID()) {
+		return fmt.Errorf("token has been revoked")
+	}
+	if v, ok := token.Get(ROOT_JTI_CLAIM); ok && jt.tokens != nil && jt.tokens.IsRevoked(fmt.Sprint(v)) {
+		return fmt.Errorf("token has been revoked")
+	}
 	return nil
 }
 
@@ -91,6 +331,19 @@ func (jt *JWT) Authenticator(next http.Handler) http.Handler {
 			return
 		}
 		// Token is authenticated, pass it through
-		next.ServeHTTP(w, r)
+		identity := Identity{
+			Method: AUTH_METHOD_JWT,
+		}
+		if v, ok := token.Get(jt.scopesClaim); ok {
+			identity.Scopes = parseScopesClaim(v)
+		} else if jt.legacyScopelessTokens {
+			identity.Scopes = legacyScopes(token)
+		}
+		if jt.identifierField != "" {
+			if v, ok := token.Get(jt.identifierField); ok {
+				identity.Name = fmt.Sprint(v)
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(withIdentity(r.Context(), identity)))
 	})
 }
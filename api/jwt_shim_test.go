@@ -2,7 +2,17 @@ package api_test
 
 import (
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -66,3 +76,70 @@ func TestJwt(t *testing.T) {
 		})
 	}
 }
+
+func TestJwtKeyFiles(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir := t.TempDir()
+	writeKeys := func(name string, key crypto.Signer) (string, string) {
+		privBytes, err := x509.MarshalPKCS8PrivateKey(key)
+		require.NoError(t, err)
+		pubBytes, err := x509.MarshalPKIXPublicKey(key.Public())
+		require.NoError(t, err)
+		privPath := filepath.Join(dir, name+".key")
+		pubPath := filepath.Join(dir, name+".pub")
+		require.NoError(t, os.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privBytes}), 0600))
+		require.NoError(t, os.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubBytes}), 0600))
+		return privPath, pubPath
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	type test struct {
+		name string
+		alg  string
+		key  crypto.Signer
+	}
+	tests := []test{
+		{name: "rsa", alg: "RS256", key: rsaKey},
+		{name: "ecdsa", alg: "ES256", key: ecKey},
+		{name: "ed25519", alg: "EdDSA", key: edKey},
+	}
+	_, otherPub := writeKeys("other", otherKey)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			privPath, pubPath := writeKeys(tt.name, tt.key)
+			signer, err := api.NewJWTFromKeyFiles(tt.alg, privPath, nil, "user_id", 300)
+			require.NoError(t, err)
+			encodedJwt, err := signer.Encode("validUser", nil)
+			require.NoError(t, err)
+
+			// the api server only requires the public key, and may trust several keys during rotation
+			verifier, err := api.NewJWTFromKeyFiles(tt.alg, "", []string{otherPub, pubPath}, "user_id", 300)
+			require.NoError(t, err)
+			decodedJwt, err := verifier.Decode(ctx, encodedJwt)
+			require.NoError(t, err)
+			require.NoError(t, verifier.CheckToken(decodedJwt))
+			_, err = verifier.Encode("validUser", nil)
+			require.Error(t, err)
+
+			// tokens signed by an untrusted key are rejected
+			untrusted, err := api.NewJWTFromKeyFiles(tt.alg, "", []string{otherPub}, "user_id", 300)
+			require.NoError(t, err)
+			_, err = untrusted.Decode(ctx, encodedJwt)
+			require.Error(t, err)
+
+			// a public key can't be used to sign tokens
+			_, err = api.NewJWTFromKeyFiles(tt.alg, pubPath, nil, "user_id", 300)
+			require.Error(t, err)
+		})
+	}
+	_, err = api.NewJWTFromKeyFiles("HS256", "", []string{otherPub}, "user_id", 300)
+	require.Error(t, err)
+}
@@ -0,0 +1,203 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/teamscanworks/breaker/breakerclient"
+	"go.uber.org/zap"
+)
+
+const (
+	// time for which the message type urls registered by the chain are cached
+	messageCacheTTL = time.Minute * 10
+	// maximum number of suggestions returned for an unknown url
+	maxUrlSuggestions = 3
+)
+
+// The message type urls registered by the chain, returned by `/v1/status/list/messages`
+type MessagesResponse struct {
+	Urls []string
+	// time at which the urls were fetched from the chain
+	UpdatedAt time.Time
+}
+
+// An unknown module request url, and registered urls which closely match it
+type InvalidUrl struct {
+	Url         string
+	Suggestions []string
+}
+
+// Returned with a 400 Bad Request response when a payload contains unknown module request urls
+type InvalidUrlsResponse struct {
+	Message     string
+	InvalidUrls []InvalidUrl
+}
+
+// Caches the message type urls registered by the chain, which are fetched through the reflection service
+type messageCache struct {
+	fetch func(ctx context.Context) ([]string, error)
+
+	mu        sync.Mutex
+	urls      map[string]bool
+	list      []string
+	updatedAt time.Time
+}
+
+func newMessageCache(fetch func(ctx context.Context) ([]string, error)) *messageCache {
+	return &messageCache{fetch: fetch}
+}
+
+// Returns the cached urls, refreshing them once the cache has expired. If the urls can't be
+// refreshed, the previously cached urls are returned along with the error.
+func (mc *messageCache) get(ctx context.Context) (map[string]bool, MessagesResponse, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mc.urls == nil || time.Since(mc.updatedAt) > messageCacheTTL {
+		list, err := mc.fetch(ctx)
+		if err != nil {
+			return mc.urls, MessagesResponse{Urls: mc.list, UpdatedAt: mc.updatedAt}, err
+		}
+		mc.urls = make(map[string]bool, len(list))
+		for _, url := range list {
+			mc.urls[url] = true
+		}
+		mc.list = list
+		mc.updatedAt = time.Now().UTC()
+	}
+	return mc.urls, MessagesResponse{Urls: mc.list, UpdatedAt: mc.updatedAt}, nil
+}
+
+// Expands glob patterns in `urls`, such as `/cosmos.bank.v1beta1.*`, against the urls registered by the chain,
+// returning the expanded urls along with the patterns which were expanded. If the registered urls can't be
+// fetched, patterns can't be expanded and an error is returned.
+func (api *API) expandUrls(ctx context.Context, urls []string) ([]string, []string, error) {
+	var patterns []string
+	for _, url := range urls {
+		if breakerclient.IsPattern(url) {
+			patterns = append(patterns, url)
+		}
+	}
+	if len(patterns) == 0 {
+		return urls, nil, nil
+	}
+	if api.messages == nil {
+		return nil, nil, fmt.Errorf("no initialized breaker client")
+	}
+	_, messages, err := api.messages.get(ctx)
+	if err != nil && messages.Urls == nil {
+		return nil, nil, fmt.Errorf("failed to fetch registered messages %s", err)
+	}
+	expanded, err := breakerclient.ExpandPatterns(urls, messages.Urls)
+	if err != nil {
+		return nil, nil, err
+	}
+	return expanded, patterns, nil
+}
+
+// Returns the urls which are not registered by the chain, along with suggestions for each. If the registered
+// urls can't be fetched, validation is skipped, as rejecting every payload would prevent tripping circuits.
+func (api *API) validateUrls(ctx context.Context, urls []string) []InvalidUrl {
+	if api.messages == nil {
+		return nil
+	}
+	known, messages, err := api.messages.get(ctx)
+	if err != nil {
+		api.logger.Warn("failed to fetch registered messages", zap.Error(err))
+	}
+	if known == nil {
+		return nil
+	}
+	var invalid []InvalidUrl
+	for _, url := range urls {
+		if !known[url] {
+			invalid = append(invalid, InvalidUrl{Url: url, Suggestions: suggestUrls(url, messages.Urls)})
+		}
+	}
+	return invalid
+}
+
+// Writes a 400 Bad Request response listing the invalid urls, returning true if any urls are invalid
+func (api *API) rejectInvalidUrls(w http.ResponseWriter, r *http.Request, urls []string) bool {
+	invalid := api.validateUrls(r.Context(), urls)
+	if len(invalid) == 0 {
+		return false
+	}
+	api.writeJSON(w, http.StatusBadRequest, &InvalidUrlsResponse{
+		Message:     "unknown module request urls",
+		InvalidUrls: invalid,
+	})
+	return true
+}
+
+// Returns the known urls closest to `url`, ordered by edit distance. Case is ignored, so the correctly
+// capitalized url is always suggested for urls with the wrong case.
+func suggestUrls(url string, known []string) []string {
+	type candidate struct {
+		url      string
+		distance int
+	}
+	lowered := strings.ToLower(url)
+	maxDistance := len(url)/3 + 1
+	var candidates []candidate
+	for _, k := range known {
+		if distance := levenshtein(lowered, strings.ToLower(k)); distance <= maxDistance {
+			candidates = append(candidates, candidate{url: k, distance: distance})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].distance < candidates[j].distance
+	})
+	var suggestions []string
+	for i := 0; i < len(candidates) && i < maxUrlSuggestions; i++ {
+		suggestions = append(suggestions, candidates[i].url)
+	}
+	return suggestions
+}
+
+// Returns the number of single character insertions, deletions or substitutions required to turn `a` into `b`
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = prev[j] + 1
+			if curr[j-1]+1 < curr[j] {
+				curr[j] = curr[j-1] + 1
+			}
+			if prev[j-1]+cost < curr[j] {
+				curr[j] = prev[j-1] + cost
+			}
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// Returns the list of message type urls registered by the chain
+func (api *API) ListMessages(w http.ResponseWriter, r *http.Request) {
+	if api.messages == nil {
+		http.Error(w, "no initialized breaker client", http.StatusInternalServerError)
+		return
+	}
+	_, messages, err := api.messages.get(r.Context())
+	if err != nil && messages.Urls == nil {
+		api.logger.Error("failed to fetch registered messages", zap.Error(err))
+		http.Error(w, fmt.Sprintf("failed to fetch registered messages %s", err), http.StatusBadGateway)
+		return
+	}
+	api.serveJSON(w, r, &messages)
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestMessageCache(t *testing.T) {
+	ctx := context.Background()
+	fetches := 0
+	fail := false
+	cache := newMessageCache(func(ctx context.Context) ([]string, error) {
+		fetches++
+		if fail {
+			return nil, fmt.Errorf("reflection service unavailable")
+		}
+		return []string{"/cosmos.bank.v1beta1.MsgSend", "/cosmos.staking.v1beta1.MsgDelegate"}, nil
+	})
+
+	urls, messages, err := cache.get(ctx)
+	require.NoError(t, err)
+	require.True(t, urls["/cosmos.bank.v1beta1.MsgSend"])
+	require.Len(t, messages.Urls, 2)
+	_, _, err = cache.get(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, fetches)
+
+	// stale urls are returned when they can't be refreshed
+	fail = true
+	cache.updatedAt = time.Now().Add(-messageCacheTTL * 2)
+	urls, _, err = cache.get(ctx)
+	require.Error(t, err)
+	require.True(t, urls["/cosmos.bank.v1beta1.MsgSend"])
+	require.Equal(t, 2, fetches)
+}
+
+func TestValidateUrls(t *testing.T) {
+	api := &API{logger: zap.NewNop()}
+	require.Empty(t, api.validateUrls(context.Background(), []string{"/unknown"}))
+
+	api.messages = newMessageCache(func(ctx context.Context) ([]string, error) {
+		return []string{"/cosmos.bank.v1beta1.MsgSend", "/cosmos.bank.v1beta1.MsgMultiSend", "/cosmos.staking.v1beta1.MsgDelegate"}, nil
+	})
+	invalid := api.validateUrls(context.Background(), []string{
+		"/cosmos.bank.v1beta1.MsgSend",
+		"/cosmos.bank.v1beta1.MsgSnd",
+		"/cosmos.bank.v1beta1.msgsend",
+		"/foo",
+	})
+	require.Len(t, invalid, 3)
+	require.Equal(t, "/cosmos.bank.v1beta1.MsgSnd", invalid[0].Url)
+	require.Equal(t, []string{"/cosmos.bank.v1beta1.MsgSend", "/cosmos.bank.v1beta1.MsgMultiSend"}, invalid[0].Suggestions)
+	require.Equal(t, "/cosmos.bank.v1beta1.MsgSend", invalid[1].Suggestions[0])
+	require.Empty(t, invalid[2].Suggestions)
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/v1/webhook", nil)
+	require.True(t, api.rejectInvalidUrls(recorder, req, []string{"/cosmos.bank.v1beta1.MsgSnd"}))
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+	var response InvalidUrlsResponse
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	require.Len(t, response.InvalidUrls, 1)
+	require.False(t, api.rejectInvalidUrls(httptest.NewRecorder(), req, []string{"/cosmos.bank.v1beta1.MsgSend"}))
+
+	// validation is skipped when the urls can't be fetched
+	api.messages = newMessageCache(func(ctx context.Context) ([]string, error) {
+		return nil, fmt.Errorf("reflection service unavailable")
+	})
+	require.Empty(t, api.validateUrls(context.Background(), []string{"/unknown"}))
+}
+
+func TestExpandUrls(t *testing.T) {
+	api := &API{logger: zap.NewNop()}
+	urls, patterns, err := api.expandUrls(context.Background(), []string{"/cosmos.bank.v1beta1.MsgSend"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"/cosmos.bank.v1beta1.MsgSend"}, urls)
+	require.Empty(t, patterns)
+	_, _, err = api.expandUrls(context.Background(), []string{"/cosmos.bank.v1beta1.*"})
+	require.Error(t, err)
+
+	api.messages = newMessageCache(func(ctx context.Context) ([]string, error) {
+		return []string{"/cosmos.bank.v1beta1.MsgMultiSend", "/cosmos.bank.v1beta1.MsgSend", "/cosmos.staking.v1beta1.MsgDelegate"}, nil
+	})
+	urls, patterns, err = api.expandUrls(context.Background(), []string{"/cosmos.bank.v1beta1.*", "/cosmos.staking.v1beta1.MsgDelegate"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"/cosmos.bank.v1beta1.MsgMultiSend", "/cosmos.bank.v1beta1.MsgSend", "/cosmos.staking.v1beta1.MsgDelegate"}, urls)
+	require.Equal(t, []string{"/cosmos.bank.v1beta1.*"}, patterns)
+	_, _, err = api.expandUrls(context.Background(), []string{"/cosmos.gov.v1.*"})
+	require.ErrorContains(t, err, "matches no registered messages")
+}
+
+func TestLevenshtein(t *testing.T) {
+	require.Equal(t, 0, levenshtein("abc", "abc"))
+	require.Equal(t, 1, levenshtein("abc", "abd"))
+	require.Equal(t, 1, levenshtein("abc", "ab"))
+	require.Equal(t, 3, levenshtein("", "abc"))
+	require.Equal(t, 3, levenshtein("kitten", "sitting"))
+}
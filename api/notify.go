@@ -0,0 +1,57 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// A notification posted to a webhook url. The `text` field is understood by slack and mattermost
+// incoming webhooks, while the remaining fields carry the details for other receivers.
+type Notification struct {
+	// kind of event, ex: "correction"
+	Event string `json:"event"`
+	// human readable summary of the event
+	Text    string      `json:"text"`
+	Time    time.Time   `json:"time"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// Posts notifications as json to a webhook url
+type Notifier struct {
+	url string
+	hc  *http.Client
+}
+
+// Returns a notifier posting to `url`
+func NewNotifier(url string) *Notifier {
+	return &Notifier{url: url, hc: &http.Client{Timeout: time.Second * 10}}
+}
+
+// Posts a notification of `event`, returning an error if the webhook doesn't respond with a 2xx status code
+func (n *Notifier) Notify(ctx context.Context, event string, text string, details interface{}) error {
+	data, err := json.Marshal(&Notification{Event: event, Text: text, Time: time.Now().UTC(), Details: details})
+	if err != nil {
+		return fmt.Errorf("failed to serialize notification %s", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", n.url, bytes.NewBuffer(data))
+	if err != nil {
+		return fmt.Errorf("failed to construct http request %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := n.hc.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send notification %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("notification failed with status %v: %s", res.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
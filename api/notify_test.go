@@ -0,0 +1,35 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotifier(t *testing.T) {
+	received := make(chan Notification, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var notification Notification
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&notification))
+		if notification.Event == "fail" {
+			http.Error(w, "rejected", http.StatusBadRequest)
+			return
+		}
+		received <- notification
+	}))
+	defer server.Close()
+
+	notifier := NewNotifier(server.URL)
+	require.NoError(t, notifier.Notify(context.Background(), "correction", "tripped 1 circuit", CircuitPlan{Trip: []string{"/cosmos.bank.v1beta1.MsgSend"}}))
+	notification := <-received
+	require.Equal(t, "correction", notification.Event)
+	require.Equal(t, "tripped 1 circuit", notification.Text)
+	require.False(t, notification.Time.IsZero())
+
+	err := notifier.Notify(context.Background(), "fail", "", nil)
+	require.ErrorContains(t, err, "400")
+}
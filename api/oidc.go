@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+const (
+	// claim used as the caller identity when none is configured
+	OIDC_DEFAULT_IDENTITY_CLAIM = "sub"
+	// minimum time between refreshing the jwks when a token is signed with an unknown key id
+	oidcDefaultRefreshInterval = time.Minute * 5
+)
+
+// Configures verification of tokens issued by an external OpenID Connect identity provider
+type OIDCOpts struct {
+	// expected value of the `iss` claim
+	Issuer string
+	// value which must be included in the `aud` claim, not verified if empty
+	Audience string
+	// url the provider serves its json web key set from, ex: https://example.com/.well-known/jwks.json
+	JWKSURL string
+	// path to a json web key set on disk, used instead of JWKSURL
+	JWKSFile string
+	// claim used as the identity of the caller, defaults to "sub"
+	IdentityClaim string
+	// claim containing the scopes granted to the caller, defaults to SCOPES_CLAIM
+	ScopesClaim string
+	// minimum time in seconds between refreshing the jwks, defaults to 300 seconds
+	RefreshIntervalSeconds int64
+}
+
+// Initializes a JWT object which verifies tokens issued by an OpenID Connect identity provider against the
+// provider's json web key set. The key set is cached, and refreshed when a token signed with an unknown key id
+// is received, allowing the provider to rotate its keys. The returned JWT can't be used to issue tokens.
+func NewJWTFromOIDC(ctx context.Context, opts OIDCOpts) (*JWT, error) {
+	if opts.Issuer == "" {
+		return nil, fmt.Errorf("oidc issuer must be set")
+	}
+	if (opts.JWKSURL == "") == (opts.JWKSFile == "") {
+		return nil, fmt.Errorf("exactly one of the oidc jwks url or jwks file must be set")
+	}
+	refreshInterval := oidcDefaultRefreshInterval
+	if opts.RefreshIntervalSeconds > 0 {
+		refreshInterval = time.Second * time.Duration(opts.RefreshIntervalSeconds)
+	}
+	keys := &jwksKeys{
+		url:             opts.JWKSURL,
+		file:            opts.JWKSFile,
+		refreshInterval: refreshInterval,
+	}
+	if opts.JWKSURL != "" {
+		keys.cache = jwk.NewCache(ctx)
+		if err := keys.cache.Register(opts.JWKSURL, jwk.WithMinRefreshInterval(refreshInterval)); err != nil {
+			return nil, fmt.Errorf("failed to register jwks url %s", err)
+		}
+	}
+	if err := keys.refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	jt := &JWT{
+		jwks:            keys,
+		identifierField: opts.IdentityClaim,
+		scopesClaim:     opts.ScopesClaim,
+		validateOpts:    []jwt.ValidateOption{jwt.WithIssuer(opts.Issuer)},
+	}
+	if jt.identifierField == "" {
+		jt.identifierField = OIDC_DEFAULT_IDENTITY_CLAIM
+	}
+	if jt.scopesClaim == "" {
+		jt.scopesClaim = SCOPES_CLAIM
+	}
+	if opts.Audience != "" {
+		jt.validateOpts = append(jt.validateOpts, jwt.WithAudience(opts.Audience))
+	}
+	return jt, nil
+}
+
+// A json web key set loaded from a url or file
+type jwksKeys struct {
+	url   string
+	file  string
+	cache *jwk.Cache
+	// minimum time between refreshes triggered by unknown key ids
+	refreshInterval time.Duration
+
+	mu          sync.Mutex
+	set         jwk.Set
+	refreshedAt time.Time
+}
+
+// Returns the current key set
+func (jk *jwksKeys) keySet() jwk.Set {
+	jk.mu.Lock()
+	defer jk.mu.Unlock()
+	return jk.set
+}
+
+// Fetches the key set from the url or file
+func (jk *jwksKeys) refresh(ctx context.Context) error {
+	var (
+		set jwk.Set
+		err error
+	)
+	if jk.cache != nil {
+		set, err = jk.cache.Refresh(ctx, jk.url)
+	} else {
+		var data []byte
+		if data, err = os.ReadFile(jk.file); err == nil {
+			set, err = jwk.Parse(data)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load jwks %s", err)
+	}
+	jk.mu.Lock()
+	defer jk.mu.Unlock()
+	jk.set = set
+	jk.refreshedAt = time.Now()
+	return nil
+}
+
+// Parses and verifies the signature of `token`. If verification fails the key set is refreshed, at most once per
+// refresh interval, and verification retried in case the token was signed with a newly rotated key.
+func (jk *jwksKeys) parse(ctx context.Context, token string) (jwt.Token, error) {
+	parsed, err := jk.parseWith(jk.keySet(), token)
+	if err == nil {
+		return parsed, nil
+	}
+	jk.mu.Lock()
+	stale := time.Since(jk.refreshedAt) >= jk.refreshInterval
+	jk.mu.Unlock()
+	if !stale {
+		return nil, err
+	}
+	if refreshErr := jk.refresh(ctx); refreshErr != nil {
+		return nil, err
+	}
+	return jk.parseWith(jk.keySet(), token)
+}
+
+func (jk *jwksKeys) parseWith(set jwk.Set, token string) (jwt.Token, error) {
+	// validation is disabled here because jwt.Validate is used by CheckToken
+	return jwt.Parse(
+		[]byte(token),
+		jwt.WithKeySet(set, jws.WithInferAlgorithmFromKey(true), jws.WithUseDefault(true)),
+		jwt.WithValidate(false),
+	)
+}
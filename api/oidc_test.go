@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// generates a signing key with the given key id
+func newOIDCKey(t *testing.T, kid string) jwk.Key {
+	raw, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	key, err := jwk.FromRaw(raw)
+	require.NoError(t, err)
+	require.NoError(t, key.Set(jwk.KeyIDKey, kid))
+	require.NoError(t, key.Set(jwk.AlgorithmKey, jwa.ES256))
+	return key
+}
+
+// returns the serialized public key set of the given keys
+func publicJWKS(t *testing.T, keys ...jwk.Key) []byte {
+	set := jwk.NewSet()
+	for _, key := range keys {
+		pub, err := key.PublicKey()
+		require.NoError(t, err)
+		require.NoError(t, set.AddKey(pub))
+	}
+	data, err := json.Marshal(set)
+	require.NoError(t, err)
+	return data
+}
+
+// issues a token as the identity provider would
+func issueOIDCToken(t *testing.T, key jwk.Key, issuer string, audience string) string {
+	token, err := jwt.NewBuilder().
+		Issuer(issuer).
+		Audience([]string{audience}).
+		Subject("alice@example.com").
+		IssuedAt(time.Now()).
+		Expiration(time.Now().Add(time.Minute)).
+		Claim("scp", "circuit:trip circuit:read").
+		Build()
+	require.NoError(t, err)
+	signed, err := jwt.Sign(token, jwt.WithKey(jwa.ES256, key))
+	require.NoError(t, err)
+	return string(signed)
+}
+
+func TestOIDC(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	const (
+		issuer   = "https://accounts.example.com"
+		audience = "breaker"
+	)
+	key1 := newOIDCKey(t, "key-1")
+	key2 := newOIDCKey(t, "key-2")
+
+	// checks tokens are verified, and rotated keys are accepted once published
+	verify := func(t *testing.T, jt *JWT, publish func(data []byte)) {
+		api := &API{logger: zap.NewNop(), jwt: jt}
+		var identity Identity
+		handler := api.Authenticator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity = IdentityFromContext(r.Context())
+		}))
+		send := func(token string) int {
+			req := httptest.NewRequest(http.MethodPost, "/v1/webhook", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		require.Equal(t, http.StatusOK, send(issueOIDCToken(t, key1, issuer, audience)))
+		require.Equal(t, "alice@example.com", identity.Name)
+		require.Equal(t, AUTH_METHOD_JWT, identity.Method)
+		require.Equal(t, []string{SCOPE_CIRCUIT_TRIP, SCOPE_CIRCUIT_READ}, identity.Scopes)
+
+		require.Equal(t, http.StatusUnauthorized, send(issueOIDCToken(t, key1, "https://evil.example.com", audience)))
+		require.Equal(t, http.StatusUnauthorized, send(issueOIDCToken(t, key1, issuer, "other")))
+		require.Equal(t, http.StatusUnauthorized, send(issueOIDCToken(t, key2, issuer, audience)))
+
+		// the provider rotates to a new key, which is picked up after the refresh interval
+		publish(publicJWKS(t, key1, key2))
+		time.Sleep(time.Second)
+		require.Equal(t, http.StatusOK, send(issueOIDCToken(t, key2, issuer, audience)))
+
+		_, err := jt.Encode("alice", nil)
+		require.Error(t, err)
+	}
+
+	t.Run("jwks_file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "jwks.json")
+		require.NoError(t, os.WriteFile(path, publicJWKS(t, key1), 0600))
+		jt, err := NewJWTFromOIDC(ctx, OIDCOpts{
+			Issuer:                 issuer,
+			Audience:               audience,
+			JWKSFile:               path,
+			ScopesClaim:            "scp",
+			RefreshIntervalSeconds: 1,
+		})
+		require.NoError(t, err)
+		verify(t, jt, func(data []byte) {
+			require.NoError(t, os.WriteFile(path, data, 0600))
+		})
+	})
+	t.Run("jwks_url", func(t *testing.T) {
+		jwks := publicJWKS(t, key1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(jwks)
+		}))
+		defer server.Close()
+		jt, err := NewJWTFromOIDC(ctx, OIDCOpts{
+			Issuer:                 issuer,
+			Audience:               audience,
+			JWKSURL:                server.URL,
+			ScopesClaim:            "scp",
+			RefreshIntervalSeconds: 1,
+		})
+		require.NoError(t, err)
+		verify(t, jt, func(data []byte) {
+			jwks = data
+		})
+	})
+	t.Run("token_issuance_disabled", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "jwks.json")
+		require.NoError(t, os.WriteFile(path, publicJWKS(t, key1), 0600))
+		jt, err := NewJWTFromOIDC(ctx, OIDCOpts{Issuer: issuer, JWKSFile: path})
+		require.NoError(t, err)
+		require.False(t, jt.CanSign())
+		_, err = NewAPI(ctx, zap.NewNop(), jt, ApiOpts{APIKeys: []APIKey{{Name: "monitoring", Key: "key123"}}}, nil)
+		require.ErrorContains(t, err, "can't be used with oidc")
+
+		// tokens are issued by the provider, so the token routes aren't served
+		api, err := NewAPI(ctx, zap.NewNop(), jt, ApiOpts{}, nil)
+		require.NoError(t, err)
+		for _, path := range []string{"/v1/auth/token", "/v1/auth/refresh"} {
+			rec := httptest.NewRecorder()
+			api.router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+			require.Equal(t, http.StatusNotFound, rec.Code)
+		}
+	})
+	t.Run("invalid_opts", func(t *testing.T) {
+		_, err := NewJWTFromOIDC(ctx, OIDCOpts{Issuer: issuer})
+		require.Error(t, err)
+		_, err = NewJWTFromOIDC(ctx, OIDCOpts{JWKSFile: "jwks.json"})
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,322 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	// interval at which scheduled resets are checked
+	scheduledResetInterval = time.Second
+	// time to wait before retrying a failed scheduled reset
+	scheduledResetRetry = time.Second * 30
+)
+
+// An automatic reset of circuits, scheduled when tripping circuits with a ttl or reset time
+type ScheduledReset struct {
+	ID   string
+	Urls []string
+	// reason given when the circuits were tripped
+	Message string
+	// identity which tripped the circuits, and is recorded as the caller of the reset
+	Identity   string
+	AuthMethod string
+	// transaction hash of the trip
+	TripTxHash string
+	CreatedAt  time.Time
+	// time at which the circuits are reset
+	ResetAt time.Time
+	// number of failed attempts to reset the circuits, and the error of the latest attempt
+	Attempts  int
+	LastError string `json:",omitempty"`
+	// time of the next attempt after a failure
+	RetryAt time.Time `json:",omitempty"`
+}
+
+// Returns true if the reset should be attempted at `now`
+func (sr *ScheduledReset) due(now time.Time) bool {
+	if !sr.RetryAt.IsZero() {
+		return !now.Before(sr.RetryAt)
+	}
+	return !now.Before(sr.ResetAt)
+}
+
+// Store of pending scheduled resets, persisted as a json file when a path is given
+type resetStore struct {
+	path string
+
+	mu     sync.Mutex
+	resets map[string]*ScheduledReset
+}
+
+// Opens the reset store persisted at `path`, which is created on the first write if it doesn't exist.
+// If `path` is empty, scheduled resets are only kept in memory.
+func openResetStore(path string) (*resetStore, error) {
+	rs := &resetStore{
+		path:   path,
+		resets: make(map[string]*ScheduledReset),
+	}
+	if path == "" {
+		return rs, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return rs, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read scheduled resets %s", err)
+	}
+	var resets []ScheduledReset
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &resets); err != nil {
+			return nil, fmt.Errorf("failed to parse scheduled resets %s", err)
+		}
+	}
+	for i := range resets {
+		rs.resets[resets[i].ID] = &resets[i]
+	}
+	return rs, nil
+}
+
+// Schedules a reset, returning a copy of it. The reset is scheduled even if it fails to be persisted.
+func (rs *resetStore) add(reset ScheduledReset) (ScheduledReset, error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return ScheduledReset{}, fmt.Errorf("failed to generate scheduled reset id %s", err)
+	}
+	reset.ID = hex.EncodeToString(idBytes)
+	reset.CreatedAt = time.Now().UTC()
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.resets[reset.ID] = &reset
+	return reset, rs.save()
+}
+
+// Cancels the scheduled reset with the given id, returning the cancelled reset
+func (rs *resetStore) cancel(id string) (ScheduledReset, bool, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	reset, ok := rs.resets[id]
+	if !ok {
+		return ScheduledReset{}, false, nil
+	}
+	delete(rs.resets, id)
+	return *reset, true, rs.save()
+}
+
+// Returns copies of all scheduled resets, ordered by reset time
+func (rs *resetStore) list() []ScheduledReset {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	resets := make([]ScheduledReset, 0, len(rs.resets))
+	for _, reset := range rs.resets {
+		resets = append(resets, *reset)
+	}
+	sort.Slice(resets, func(i, j int) bool {
+		return resets[i].ResetAt.Before(resets[j].ResetAt)
+	})
+	return resets
+}
+
+// Returns copies of the resets which should be attempted at `now`
+func (rs *resetStore) due(now time.Time) []ScheduledReset {
+	var due []ScheduledReset
+	for _, reset := range rs.list() {
+		if reset.due(now) {
+			due = append(due, reset)
+		}
+	}
+	return due
+}
+
+// Removes a reset which has been applied. If it was cancelled in the meantime this is a no-op.
+func (rs *resetStore) complete(id string) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if _, ok := rs.resets[id]; !ok {
+		return nil
+	}
+	delete(rs.resets, id)
+	return rs.save()
+}
+
+// Records a failed attempt to apply a reset, which is retried at `retryAt`
+func (rs *resetStore) fail(id string, resetErr string, retryAt time.Time) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	reset, ok := rs.resets[id]
+	if !ok {
+		return nil
+	}
+	reset.Attempts++
+	reset.LastError = resetErr
+	reset.RetryAt = retryAt
+	return rs.save()
+}
+
+// atomically writes the store to disk, must be called with the lock held
+func (rs *resetStore) save() error {
+	if rs.path == "" {
+		return nil
+	}
+	resets := make([]ScheduledReset, 0, len(rs.resets))
+	for _, reset := range rs.resets {
+		resets = append(resets, *reset)
+	}
+	data, err := json.MarshalIndent(resets, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize scheduled resets %s", err)
+	}
+	if err := writeFileAtomic(rs.path, data); err != nil {
+		return fmt.Errorf("failed to write scheduled resets %s", err)
+	}
+	return nil
+}
+
+// Returns the time at which circuits tripped by `payload` at `now` should be reset, or the zero time
+// if the payload doesn't request an automatic reset
+func (p *PayloadV1) resetTime(now time.Time) time.Time {
+	if p.TTL > 0 {
+		return now.Add(time.Second * time.Duration(p.TTL))
+	}
+	if p.ResetAt != nil {
+		return p.ResetAt.UTC()
+	}
+	return time.Time{}
+}
+
+// Returns an error if the automatic reset requested by `payload` is invalid
+func (api *API) validateAutoReset(payload PayloadV1) error {
+	if payload.TTL == 0 && payload.ResetAt == nil {
+		return nil
+	}
+	if payload.TTL != 0 && payload.ResetAt != nil {
+		return fmt.Errorf("only one of ttl and reset_at may be set")
+	}
+	if payload.Operation != MODE_TRIP {
+		return fmt.Errorf("ttl and reset_at are only supported when tripping circuits")
+	}
+	if payload.TTL < 0 {
+		return fmt.Errorf("ttl must be positive")
+	}
+	if payload.ResetAt != nil && !payload.ResetAt.After(time.Now()) {
+		return fmt.Errorf("reset_at must be in the future")
+	}
+	// an automatic reset would bypass the approval required to reset the circuits
+	if api.approvalPolicy != nil && api.approvalPolicy.requires(PayloadV1{Urls: payload.Urls, Operation: MODE_RESET}) {
+		return fmt.Errorf("circuits requiring approval to reset can't be reset automatically")
+	}
+	return nil
+}
+
+// Schedules the automatic reset requested by `payload` after its circuits have been tripped
+func (api *API) scheduleReset(ctx context.Context, payload PayloadV1, txHash string) *ScheduledReset {
+	resetAt := payload.resetTime(time.Now().UTC())
+	if resetAt.IsZero() {
+		return nil
+	}
+	identity := IdentityFromContext(ctx)
+	reset, err := api.resets.add(ScheduledReset{
+		Urls:       payload.Urls,
+		Message:    payload.Message,
+		Identity:   identity.Name,
+		AuthMethod: identity.Method,
+		TripTxHash: txHash,
+		ResetAt:    resetAt,
+	})
+	if reset.ID == "" {
+		api.logger.Error("failed to schedule reset", zap.Any("urls", payload.Urls), zap.Error(err))
+		return nil
+	}
+	if err != nil {
+		api.logger.Error("failed to persist scheduled reset", zap.String("reset.id", reset.ID), zap.Error(err))
+	}
+	api.logger.Info("scheduled reset", zap.String("reset.id", reset.ID), zap.Any("urls", reset.Urls), zap.Time("reset.at", reset.ResetAt))
+	return &reset
+}
+
+// Applies scheduled resets once they are due, until the api is closed
+func (api *API) runScheduledResets() {
+	ticker := time.NewTicker(scheduledResetInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-api.ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, reset := range api.resets.due(now) {
+				api.applyScheduledReset(reset)
+			}
+		}
+	}
+}
+
+// Resets the circuits of a scheduled reset which are still tripped, retrying later on failure
+func (api *API) applyScheduledReset(reset ScheduledReset) {
+	if api.breakerClient == nil {
+		return
+	}
+	ctx := withIdentity(api.ctx, Identity{Name: reset.Identity, Method: reset.AuthMethod})
+	logger := api.logger.With(zap.String("reset.id", reset.ID), zap.Any("urls", reset.Urls))
+	fail := func(resetErr string) {
+		logger.Error("failed to apply scheduled reset", zap.String("error", resetErr), zap.Int("attempts", reset.Attempts+1))
+		if err := api.resets.fail(reset.ID, resetErr, time.Now().UTC().Add(scheduledResetRetry)); err != nil {
+			logger.Error("failed to persist scheduled reset", zap.Error(err))
+		}
+	}
+
+	// circuits which have already been reset manually are skipped
+	urls, err := api.pendingUrls(ctx, MODE_RESET, reset.Urls)
+	if err != nil {
+		fail(fmt.Sprintf("failed to list disabled commands %s", err))
+		return
+	}
+	if len(urls) > 0 {
+		response := api.executePayload(ctx, PayloadV1{
+			Message:   fmt.Sprintf("scheduled reset: %s", reset.Message),
+			Urls:      urls,
+			Operation: MODE_RESET,
+		}, nil)
+		if response.Message != "ok" {
+			fail(response.Message)
+			return
+		}
+	} else {
+		logger.Info("circuits of scheduled reset were already reset")
+	}
+	if err := api.resets.complete(reset.ID); err != nil {
+		logger.Error("failed to persist scheduled reset", zap.Error(err))
+	}
+}
+
+// Returns all pending scheduled resets, ordered by the time they are applied
+func (api *API) ListScheduledResets(w http.ResponseWriter, r *http.Request) {
+	resets := api.resets.list()
+	api.serveJSON(w, r, &resets)
+}
+
+// Cancels the scheduled reset given by the `id` url parameter, leaving its circuits tripped
+func (api *API) CancelScheduledReset(w http.ResponseWriter, r *http.Request) {
+	reset, ok, err := api.resets.cancel(chi.URLParam(r, "id"))
+	if !ok {
+		http.Error(w, "scheduled reset not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.logger.Error("failed to persist scheduled reset", zap.String("reset.id", reset.ID), zap.Error(err))
+	}
+	identity := IdentityFromContext(r.Context())
+	api.logger.Info("cancelled scheduled reset", zap.String("reset.id", reset.ID), zap.Any("urls", reset.Urls), zap.String("identity", identity.Name))
+	api.serveJSON(w, r, &reset)
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/teamscanworks/breaker/breakerclient"
+	"go.uber.org/zap"
+)
+
+func TestScheduledResets(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "resets.json")
+	api, err := NewAPI(ctx, zap.NewNop(), NewJWT("password123", "userId", 3000), ApiOpts{ScheduledResetsPath: path}, nil)
+	require.NoError(t, err)
+
+	tripper := withIdentity(ctx, Identity{Name: "monitor", Method: AUTH_METHOD_HMAC})
+	scheduled := api.scheduleReset(tripper, PayloadV1{
+		Message:   "investigating exploit",
+		Urls:      []string{"/cosmos.bank.v1beta1.MsgSend"},
+		Operation: MODE_TRIP,
+		TTL:       1800,
+	}, "ABC")
+	require.NotNil(t, scheduled)
+	require.Equal(t, "monitor", scheduled.Identity)
+	require.Equal(t, "ABC", scheduled.TripTxHash)
+	require.WithinDuration(t, time.Now().Add(time.Minute*30), scheduled.ResetAt, time.Second*5)
+	resetAt := time.Now().Add(time.Hour)
+	require.NotNil(t, api.scheduleReset(tripper, PayloadV1{Urls: []string{"/cosmos.staking.v1beta1.MsgDelegate"}, ResetAt: &resetAt}, "DEF"))
+	require.Nil(t, api.scheduleReset(tripper, PayloadV1{Urls: []string{"/cosmos.staking.v1beta1.MsgDelegate"}}, "GHI"))
+
+	// scheduled resets are persisted across restarts
+	resets, err := openResetStore(path)
+	require.NoError(t, err)
+	list := resets.list()
+	require.Len(t, list, 2)
+	require.Equal(t, scheduled.ID, list[0].ID)
+	require.Equal(t, "investigating exploit", list[0].Message)
+
+	require.Empty(t, resets.due(time.Now()))
+	due := resets.due(time.Now().Add(time.Minute * 31))
+	require.Len(t, due, 1)
+	require.Equal(t, scheduled.ID, due[0].ID)
+
+	// failed resets are retried later
+	retryAt := time.Now().Add(time.Hour * 2)
+	require.NoError(t, resets.fail(scheduled.ID, "failed to reset circuit breaker", retryAt))
+	require.Len(t, resets.due(time.Now().Add(time.Minute*90)), 1)
+	require.Len(t, resets.due(retryAt), 2)
+	require.NoError(t, resets.complete(scheduled.ID))
+
+	_, ok, err := resets.cancel(list[1].ID)
+	require.True(t, ok)
+	require.NoError(t, err)
+	_, ok, _ = resets.cancel("missing")
+	require.False(t, ok)
+
+	resets, err = openResetStore(path)
+	require.NoError(t, err)
+	require.Empty(t, resets.list())
+}
+
+func TestValidateAutoReset(t *testing.T) {
+	api := &API{approvalPolicy: &ApprovalPolicy{Reset: true, Urls: []string{"/cosmos.bank.v1beta1.MsgSend"}}}
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Minute)
+	tests := []struct {
+		name    string
+		payload PayloadV1
+		err     string
+	}{
+		{"no_reset", PayloadV1{Operation: MODE_RESET}, ""},
+		{"ttl", PayloadV1{Operation: MODE_TRIP, TTL: 60}, ""},
+		{"reset_at", PayloadV1{Operation: MODE_TRIP, ResetAt: &future}, ""},
+		{"both", PayloadV1{Operation: MODE_TRIP, TTL: 60, ResetAt: &future}, "only one of"},
+		{"reset_operation", PayloadV1{Operation: MODE_RESET, TTL: 60}, "only supported when tripping"},
+		{"negative_ttl", PayloadV1{Operation: MODE_TRIP, TTL: -1}, "must be positive"},
+		{"past_reset_at", PayloadV1{Operation: MODE_TRIP, ResetAt: &past}, "must be in the future"},
+		{"requires_approval", PayloadV1{Operation: MODE_TRIP, TTL: 60, Urls: []string{"/cosmos.bank.v1beta1.MsgSend"}}, "requiring approval"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := api.validateAutoReset(tt.payload)
+			if tt.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestAutoResetRequiresResetScope(t *testing.T) {
+	// the scope checks are applied before the breaker client is used
+	api := &API{logger: zap.NewNop(), breakerClient: &breakerclient.BreakerClient{}}
+	send := func(scopes []string, payload PayloadV1) *httptest.ResponseRecorder {
+		data, err := json.Marshal(&payload)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/v1/webhook", bytes.NewReader(data))
+		req = req.WithContext(withIdentity(req.Context(), Identity{Name: "monitor", Method: AUTH_METHOD_JWT, Scopes: scopes}))
+		rec := httptest.NewRecorder()
+		api.HandleWebookV1(rec, req)
+		return rec
+	}
+	resetAt := time.Now().Add(time.Hour)
+	for _, payload := range []PayloadV1{
+		{Urls: []string{"/cosmos.bank.v1beta1.MsgSend"}, Operation: MODE_TRIP, TTL: 60},
+		{Urls: []string{"/cosmos.bank.v1beta1.MsgSend"}, Operation: MODE_TRIP, ResetAt: &resetAt},
+	} {
+		rec := send([]string{SCOPE_CIRCUIT_TRIP}, payload)
+		require.Equal(t, http.StatusForbidden, rec.Code)
+		require.Contains(t, rec.Body.String(), "missing required scope circuit:reset")
+	}
+}
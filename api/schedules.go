@@ -0,0 +1,559 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	// interval at which maintenance windows are checked
+	scheduleInterval = time.Second * 5
+	// time to wait before retrying a failed trip or reset of a maintenance window
+	scheduleRetry = time.Second * 30
+	// time for which finished maintenance windows are kept before being removed
+	scheduleRetention = time.Hour * 24 * 7
+)
+
+// Typed string representing the state of a maintenance window
+type ScheduleStatus string
+
+const (
+	// window has not started yet
+	SCHEDULE_PENDING ScheduleStatus = "pending"
+	// circuits have been tripped, and are reset once the window ends
+	SCHEDULE_ACTIVE ScheduleStatus = "active"
+	// circuits have been reset after the window ended
+	SCHEDULE_COMPLETED ScheduleStatus = "completed"
+	// window ended before its circuits could be tripped, such as when the api was not running
+	SCHEDULE_MISSED ScheduleStatus = "missed"
+)
+
+var (
+	errScheduleNotFound = fmt.Errorf("schedule not found")
+	errScheduleApplying = fmt.Errorf("window is being applied, retry shortly")
+)
+
+// The payload sent to create or update a maintenance window. A window is bounded either by
+// times, or by block heights, where the circuits are tripped at the start and reset at the end.
+type ScheduleRequest struct {
+	// reason for the maintenance window, used as the message of the trip and reset
+	Message string
+	// module request urls tripped during the window
+	Urls []string
+	// names of circuit groups whose urls are tripped during the window, which are added to Urls when the window is created
+	Groups      []string   `json:",omitempty"`
+	StartTime   *time.Time `json:",omitempty"`
+	EndTime     *time.Time `json:",omitempty"`
+	StartHeight int64      `json:",omitempty"`
+	EndHeight   int64      `json:",omitempty"`
+}
+
+// Returns an error if the window bounds are invalid
+func (sr *ScheduleRequest) validate() error {
+	if len(sr.Urls) == 0 {
+		return fmt.Errorf("at least one url must be given")
+	}
+	usesTime := sr.StartTime != nil || sr.EndTime != nil
+	usesHeight := sr.StartHeight != 0 || sr.EndHeight != 0
+	switch {
+	case usesTime && usesHeight:
+		return fmt.Errorf("a window must be bounded by either times or block heights")
+	case usesTime:
+		if sr.StartTime == nil || sr.EndTime == nil {
+			return fmt.Errorf("both the start and end time must be given")
+		}
+		if !sr.EndTime.After(*sr.StartTime) {
+			return fmt.Errorf("end time must be after the start time")
+		}
+	case usesHeight:
+		if sr.StartHeight <= 0 || sr.EndHeight <= 0 {
+			return fmt.Errorf("both the start and end height must be given")
+		}
+		if sr.EndHeight <= sr.StartHeight {
+			return fmt.Errorf("end height must be after the start height")
+		}
+	default:
+		return fmt.Errorf("a window must be bounded by either times or block heights")
+	}
+	return nil
+}
+
+// A maintenance window, during which circuits are tripped
+type Schedule struct {
+	ID string
+	ScheduleRequest
+	Status ScheduleStatus
+	// identity which created the window, and is recorded as the caller of the trip and reset
+	Identity   string
+	AuthMethod string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	// urls tripped at the start of the window, which are reset at its end. urls which were already tripped
+	// when the window started are excluded, so that circuits tripped by other means stay tripped
+	TrippedUrls []string
+	// transaction hashes of the trip and reset
+	TripTxHash  string `json:",omitempty"`
+	ResetTxHash string `json:",omitempty"`
+	// error of the latest failed trip or reset, which is retried at RetryAt
+	LastError string    `json:",omitempty"`
+	RetryAt   time.Time `json:",omitempty"`
+	// set while the circuits of the window are being tripped or reset, during which it can't be updated or
+	// deleted. not persisted, as no operation is in progress once the api restarts
+	applying bool
+}
+
+// Returns true if the window is bounded by block heights
+func (s *Schedule) usesHeight() bool {
+	return s.StartHeight > 0
+}
+
+// Returns true if the window has started at the given time and block height
+func (s *Schedule) started(now time.Time, height int64) bool {
+	if s.usesHeight() {
+		return height >= s.StartHeight
+	}
+	return !now.Before(*s.StartTime)
+}
+
+// Returns true if the window has ended at the given time and block height
+func (s *Schedule) ended(now time.Time, height int64) bool {
+	if s.usesHeight() {
+		return height >= s.EndHeight
+	}
+	return !now.Before(*s.EndTime)
+}
+
+// Returns the urls to apply `operation` to, the urls of the window when tripping, and the urls tripped by the window when resetting
+func (s *Schedule) operationUrls(operation Mode) []string {
+	// windows activated before the tripped urls were recorded reset every url
+	if operation == MODE_TRIP || s.TrippedUrls == nil {
+		return s.Urls
+	}
+	return s.TrippedUrls
+}
+
+// Returns true if the window has finished, and requires no further action
+func (s *Schedule) finished() bool {
+	return s.Status == SCHEDULE_COMPLETED || s.Status == SCHEDULE_MISSED
+}
+
+// Store of maintenance windows, persisted as a json file when a path is given
+type scheduleStore struct {
+	path string
+
+	mu        sync.Mutex
+	schedules map[string]*Schedule
+}
+
+// Opens the schedule store persisted at `path`, which is created on the first write if it doesn't exist.
+// If `path` is empty, maintenance windows are only kept in memory.
+func openScheduleStore(path string) (*scheduleStore, error) {
+	ss := &scheduleStore{
+		path:      path,
+		schedules: make(map[string]*Schedule),
+	}
+	if path == "" {
+		return ss, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ss, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read schedules %s", err)
+	}
+	var schedules []Schedule
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &schedules); err != nil {
+			return nil, fmt.Errorf("failed to parse schedules %s", err)
+		}
+	}
+	for i := range schedules {
+		ss.schedules[schedules[i].ID] = &schedules[i]
+	}
+	return ss, nil
+}
+
+// Creates a pending maintenance window requested by `identity`, returning a copy of it
+func (ss *scheduleStore) create(req ScheduleRequest, identity Identity) (Schedule, error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return Schedule{}, fmt.Errorf("failed to generate schedule id %s", err)
+	}
+	now := time.Now().UTC()
+	schedule := &Schedule{
+		ID:              hex.EncodeToString(idBytes),
+		ScheduleRequest: req,
+		Status:          SCHEDULE_PENDING,
+		Identity:        identity.Name,
+		AuthMethod:      identity.Method,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.schedules[schedule.ID] = schedule
+	return *schedule, ss.save()
+}
+
+// Returns a copy of the maintenance window with the given id
+func (ss *scheduleStore) get(id string) (Schedule, bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	schedule, ok := ss.schedules[id]
+	if !ok {
+		return Schedule{}, false
+	}
+	return *schedule, true
+}
+
+// Returns copies of all maintenance windows, ordered by creation time
+func (ss *scheduleStore) list() []Schedule {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	schedules := make([]Schedule, 0, len(ss.schedules))
+	for _, schedule := range ss.schedules {
+		schedules = append(schedules, *schedule)
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].CreatedAt.Before(schedules[j].CreatedAt)
+	})
+	return schedules
+}
+
+// Applies `fn` against the maintenance window with the given id, persisting the result unless `fn` returns an error
+func (ss *scheduleStore) update(id string, fn func(schedule *Schedule) error) (Schedule, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	schedule, ok := ss.schedules[id]
+	if !ok {
+		return Schedule{}, errScheduleNotFound
+	}
+	updated := *schedule
+	if err := fn(&updated); err != nil {
+		return Schedule{}, err
+	}
+	updated.UpdatedAt = time.Now().UTC()
+	*schedule = updated
+	return updated, ss.save()
+}
+
+// Marks the maintenance window as being applied, until its result is recorded with `update`. Returns false
+// if the window has been removed or changed since `schedule` was copied, or is already being applied.
+func (ss *scheduleStore) begin(schedule Schedule) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	current, ok := ss.schedules[schedule.ID]
+	if !ok || current.applying || !current.UpdatedAt.Equal(schedule.UpdatedAt) {
+		return false
+	}
+	current.applying = true
+	return true
+}
+
+// Removes the maintenance window with the given id, unless `fn` returns an error
+func (ss *scheduleStore) delete(id string, fn func(schedule Schedule) error) (Schedule, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	schedule, ok := ss.schedules[id]
+	if !ok {
+		return Schedule{}, errScheduleNotFound
+	}
+	if err := fn(*schedule); err != nil {
+		return Schedule{}, err
+	}
+	delete(ss.schedules, id)
+	return *schedule, ss.save()
+}
+
+// atomically writes the store to disk, removing old finished windows. must be called with the lock held
+func (ss *scheduleStore) save() error {
+	now := time.Now()
+	schedules := make([]Schedule, 0, len(ss.schedules))
+	for id, schedule := range ss.schedules {
+		if schedule.finished() && now.Sub(schedule.UpdatedAt) > scheduleRetention {
+			delete(ss.schedules, id)
+			continue
+		}
+		schedules = append(schedules, *schedule)
+	}
+	if ss.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(schedules, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize schedules %s", err)
+	}
+	if err := writeFileAtomic(ss.path, data); err != nil {
+		return fmt.Errorf("failed to write schedules %s", err)
+	}
+	return nil
+}
+
+// Trips and resets the circuits of maintenance windows as they start and end, until the api is closed
+func (api *API) runSchedules() {
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-api.ctx.Done():
+			return
+		case now := <-ticker.C:
+			api.checkSchedules(now)
+		}
+	}
+}
+
+// Advances every unfinished maintenance window which is due at `now`
+func (api *API) checkSchedules(now time.Time) {
+	if api.breakerClient == nil {
+		return
+	}
+	var (
+		height        int64
+		heightQueried bool
+	)
+	for _, schedule := range api.schedules.list() {
+		if schedule.finished() || (!schedule.RetryAt.IsZero() && now.Before(schedule.RetryAt)) {
+			continue
+		}
+		// the block height is only queried when a window bounded by heights needs to be checked
+		if schedule.usesHeight() && !heightQueried {
+			var err error
+			if height, err = api.breakerClient.LatestHeight(api.ctx); err != nil {
+				api.logger.Error("failed to query latest block height", zap.Error(err))
+				continue
+			}
+			heightQueried = true
+		}
+		api.advanceSchedule(schedule, now, height)
+	}
+}
+
+// Trips the circuits of a pending window which has started, and resets the circuits of an active window which has ended
+func (api *API) advanceSchedule(schedule Schedule, now time.Time, height int64) {
+	var (
+		operation Mode
+		status    ScheduleStatus
+	)
+	switch {
+	case schedule.Status == SCHEDULE_PENDING && schedule.ended(now, height):
+		api.logger.Warn("maintenance window ended before it started", zap.String("schedule.id", schedule.ID))
+		api.setScheduleResult(schedule.ID, SCHEDULE_MISSED, "", nil, "")
+		return
+	case schedule.Status == SCHEDULE_PENDING && schedule.started(now, height):
+		operation, status = MODE_TRIP, SCHEDULE_ACTIVE
+	case schedule.Status == SCHEDULE_ACTIVE && schedule.ended(now, height):
+		operation, status = MODE_RESET, SCHEDULE_COMPLETED
+	default:
+		return
+	}
+	// updates and deletes are rejected until the result is recorded, so the window can't change while applied
+	if !api.schedules.begin(schedule) {
+		return
+	}
+
+	ctx := withIdentity(api.ctx, Identity{Name: schedule.Identity, Method: schedule.AuthMethod})
+	logger := api.logger.With(zap.String("schedule.id", schedule.ID), zap.Stringer("operation", operation))
+	// circuits which are already in the requested state are skipped
+	urls, err := api.pendingUrls(ctx, operation, schedule.operationUrls(operation))
+	if err != nil {
+		logger.Error("failed to list disabled commands", zap.Error(err))
+		api.setScheduleResult(schedule.ID, schedule.Status, "", nil, fmt.Sprintf("failed to list disabled commands %s", err))
+		return
+	}
+	var txHash string
+	if len(urls) > 0 {
+		response := api.executePayload(ctx, PayloadV1{
+			Message:   fmt.Sprintf("maintenance window: %s", schedule.Message),
+			Urls:      urls,
+			Operation: operation,
+		}, nil)
+		if response.Message != "ok" {
+			logger.Error("failed to apply maintenance window", zap.String("error", response.Message))
+			api.setScheduleResult(schedule.ID, schedule.Status, "", nil, response.Message)
+			return
+		}
+		txHash = response.TxHash
+	}
+	logger.Info("applied maintenance window", zap.Any("urls", urls), zap.String("tx.hash", txHash))
+	api.setScheduleResult(schedule.ID, status, txHash, urls, "")
+}
+
+// Records the result of tripping or resetting the `urls` of a maintenance window. When `resultErr` is set
+// the status is unchanged, and the operation is retried later.
+func (api *API) setScheduleResult(id string, status ScheduleStatus, txHash string, urls []string, resultErr string) {
+	_, err := api.schedules.update(id, func(schedule *Schedule) error {
+		schedule.applying = false
+		schedule.LastError = resultErr
+		schedule.RetryAt = time.Time{}
+		if resultErr != "" {
+			schedule.RetryAt = time.Now().UTC().Add(scheduleRetry)
+			return nil
+		}
+		if status == SCHEDULE_ACTIVE {
+			schedule.TripTxHash = txHash
+			// recorded as an empty list rather than nil when every url was already tripped
+			schedule.TrippedUrls = append([]string{}, urls...)
+		} else if status == SCHEDULE_COMPLETED {
+			schedule.ResetTxHash = txHash
+		}
+		schedule.Status = status
+		return nil
+	})
+	switch {
+	case err == errScheduleNotFound:
+		api.logger.Error("maintenance window was removed before its result was recorded",
+			zap.String("schedule.id", id), zap.Any("urls", urls), zap.String("tx.hash", txHash))
+	case err != nil:
+		api.logger.Error("failed to persist schedule", zap.String("schedule.id", id), zap.Error(err))
+	}
+}
+
+// Reads and validates the maintenance window sent in the request body
+func (api *API) readScheduleRequest(r *http.Request) (ScheduleRequest, error) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return ScheduleRequest{}, err
+	}
+	var req ScheduleRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return ScheduleRequest{}, err
+	}
+	if req.Urls, _, err = api.resolveGroups(req.Urls, req.Groups); err != nil {
+		return ScheduleRequest{}, err
+	}
+	if req.Urls, _, err = api.expandUrls(r.Context(), req.Urls); err != nil {
+		return ScheduleRequest{}, err
+	}
+	if err := req.validate(); err != nil {
+		return ScheduleRequest{}, err
+	}
+	// circuits are tripped and reset automatically, bypassing any approval required for them
+	if api.approvalPolicy != nil &&
+		(api.approvalPolicy.requires(PayloadV1{Urls: req.Urls, Operation: MODE_TRIP}) ||
+			api.approvalPolicy.requires(PayloadV1{Urls: req.Urls, Operation: MODE_RESET})) {
+		return ScheduleRequest{}, fmt.Errorf("circuits requiring approval can't be scheduled")
+	}
+	return req, nil
+}
+
+// Creates a maintenance window
+func (api *API) CreateSchedule(w http.ResponseWriter, r *http.Request) {
+	req, err := api.readScheduleRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if api.rejectInvalidUrls(w, r, req.Urls) {
+		return
+	}
+	identity := IdentityFromContext(r.Context())
+	schedule, err := api.schedules.create(req, identity)
+	if schedule.ID == "" {
+		api.logger.Error("failed to create schedule", zap.Error(err))
+		http.Error(w, "failed to create schedule", http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		api.logger.Error("failed to persist schedule", zap.String("schedule.id", schedule.ID), zap.Error(err))
+	}
+	api.logger.Info("created maintenance window", zap.String("schedule.id", schedule.ID), zap.Any("urls", schedule.Urls), zap.String("identity", identity.Name))
+	api.writeJSON(w, http.StatusCreated, &schedule)
+}
+
+// Returns all maintenance windows, including those finished within the last week
+func (api *API) ListSchedules(w http.ResponseWriter, r *http.Request) {
+	schedules := api.schedules.list()
+	api.serveJSON(w, r, &schedules)
+}
+
+// Returns the maintenance window given by the `id` url parameter
+func (api *API) GetSchedule(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := api.schedules.get(chi.URLParam(r, "id"))
+	if !ok {
+		http.Error(w, errScheduleNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	api.serveJSON(w, r, &schedule)
+}
+
+// Updates the maintenance window given by the `id` url parameter. Pending windows may be changed freely, while
+// only the end of an active window may be changed, such as to end the window early.
+func (api *API) UpdateSchedule(w http.ResponseWriter, r *http.Request) {
+	req, err := api.readScheduleRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if api.rejectInvalidUrls(w, r, req.Urls) {
+		return
+	}
+	schedule, err := api.schedules.update(chi.URLParam(r, "id"), func(schedule *Schedule) error {
+		if schedule.applying {
+			return errScheduleApplying
+		}
+		switch schedule.Status {
+		case SCHEDULE_PENDING:
+		case SCHEDULE_ACTIVE:
+			if !equalUrls(req.Urls, schedule.Urls) || req.StartHeight != schedule.StartHeight ||
+				(req.StartTime != nil) != (schedule.StartTime != nil) ||
+				(req.StartTime != nil && !req.StartTime.Equal(*schedule.StartTime)) {
+				return fmt.Errorf("only the end of an active window may be changed")
+			}
+		default:
+			return fmt.Errorf("window is %s", schedule.Status)
+		}
+		schedule.ScheduleRequest = req
+		return nil
+	})
+	switch {
+	case err == errScheduleNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case schedule.ID == "":
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		api.logger.Error("failed to persist schedule", zap.String("schedule.id", schedule.ID), zap.Error(err))
+	}
+	api.logger.Info("updated maintenance window", zap.String("schedule.id", schedule.ID), zap.String("identity", IdentityFromContext(r.Context()).Name))
+	api.serveJSON(w, r, &schedule)
+}
+
+// Deletes the maintenance window given by the `id` url parameter. Active windows can't be deleted, as their
+// circuits would remain tripped, instead their end should be updated.
+func (api *API) DeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	schedule, err := api.schedules.delete(chi.URLParam(r, "id"), func(schedule Schedule) error {
+		if schedule.applying {
+			return errScheduleApplying
+		}
+		if schedule.Status == SCHEDULE_ACTIVE {
+			return fmt.Errorf("active windows can't be deleted, update the end of the window instead")
+		}
+		return nil
+	})
+	switch {
+	case err == errScheduleNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case schedule.ID == "":
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		api.logger.Error("failed to persist schedule", zap.String("schedule.id", schedule.ID), zap.Error(err))
+	}
+	api.logger.Info("deleted maintenance window", zap.String("schedule.id", schedule.ID), zap.String("identity", IdentityFromContext(r.Context()).Name))
+	api.serveJSON(w, r, &schedule)
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestScheduleWindow(t *testing.T) {
+	now := time.Now()
+	start, end := now.Add(time.Hour), now.Add(time.Hour*2)
+	schedule := Schedule{ScheduleRequest: ScheduleRequest{StartTime: &start, EndTime: &end}}
+	require.False(t, schedule.started(now, 0))
+	require.True(t, schedule.started(start, 0))
+	require.False(t, schedule.ended(start, 0))
+	require.True(t, schedule.ended(end, 0))
+
+	schedule = Schedule{ScheduleRequest: ScheduleRequest{StartHeight: 100, EndHeight: 200}}
+	require.True(t, schedule.usesHeight())
+	require.False(t, schedule.started(now, 99))
+	require.True(t, schedule.started(now, 100))
+	require.True(t, schedule.ended(now, 200))
+
+	tests := []struct {
+		name string
+		req  ScheduleRequest
+		err  string
+	}{
+		{"times", ScheduleRequest{Urls: []string{"/a"}, StartTime: &start, EndTime: &end}, ""},
+		{"heights", ScheduleRequest{Urls: []string{"/a"}, StartHeight: 100, EndHeight: 200}, ""},
+		{"no_urls", ScheduleRequest{StartHeight: 100, EndHeight: 200}, "at least one url"},
+		{"no_bounds", ScheduleRequest{Urls: []string{"/a"}}, "either times or block heights"},
+		{"mixed", ScheduleRequest{Urls: []string{"/a"}, StartTime: &start, EndHeight: 200}, "either times or block heights"},
+		{"missing_end", ScheduleRequest{Urls: []string{"/a"}, StartTime: &start}, "both the start and end time"},
+		{"reversed_times", ScheduleRequest{Urls: []string{"/a"}, StartTime: &end, EndTime: &start}, "end time must be after"},
+		{"reversed_heights", ScheduleRequest{Urls: []string{"/a"}, StartHeight: 200, EndHeight: 100}, "end height must be after"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.validate()
+			if tt.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestSchedules(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "schedules.json")
+	api, err := NewAPI(ctx, zap.NewNop(), NewJWT("password123", "userId", 3000), ApiOpts{SchedulesPath: path}, nil)
+	require.NoError(t, err)
+	server := httptest.NewServer(api.router)
+	defer server.Close()
+
+	token, err := api.jwt.Encode("operator", map[string]interface{}{
+		SCOPES_CLAIM: []string{SCOPE_CIRCUIT_TRIP, SCOPE_CIRCUIT_RESET, SCOPE_CIRCUIT_READ},
+	})
+	require.NoError(t, err)
+	client := NewAPIClient(server.URL, token)
+
+	start, end := time.Now().Add(time.Hour).UTC(), time.Now().Add(time.Hour*2).UTC()
+	schedule, err := client.CreateSchedule(ScheduleRequest{
+		Message:   "chain upgrade",
+		Urls:      []string{"/cosmos.bank.v1beta1.MsgSend"},
+		StartTime: &start,
+		EndTime:   &end,
+	})
+	require.NoError(t, err)
+	require.Equal(t, SCHEDULE_PENDING, schedule.Status)
+	require.Equal(t, "operator", schedule.Identity)
+
+	_, err = client.CreateSchedule(ScheduleRequest{Urls: []string{"/cosmos.bank.v1beta1.MsgSend"}})
+	require.ErrorContains(t, err, "400")
+
+	readToken, err := api.jwt.Encode("monitor", map[string]interface{}{SCOPES_CLAIM: []string{SCOPE_CIRCUIT_READ}})
+	require.NoError(t, err)
+	readClient := NewAPIClient(server.URL, readToken)
+	schedules, err := readClient.Schedules()
+	require.NoError(t, err)
+	require.Len(t, schedules, 1)
+	_, err = readClient.DeleteSchedule(schedule.ID)
+	require.ErrorContains(t, err, "403")
+
+	later := end.Add(time.Hour)
+	updated, err := client.UpdateSchedule(schedule.ID, ScheduleRequest{
+		Message:   "chain upgrade",
+		Urls:      []string{"/cosmos.bank.v1beta1.MsgSend"},
+		StartTime: &start,
+		EndTime:   &later,
+	})
+	require.NoError(t, err)
+	require.True(t, later.Equal(*updated.EndTime))
+
+	// windows are persisted across restarts
+	schedules2, err := openScheduleStore(path)
+	require.NoError(t, err)
+	persisted, ok := schedules2.get(schedule.ID)
+	require.True(t, ok)
+	require.True(t, later.Equal(*persisted.EndTime))
+
+	// only the end of active windows may be changed, and they can't be deleted
+	_, err = api.schedules.update(schedule.ID, func(s *Schedule) error {
+		s.Status = SCHEDULE_ACTIVE
+		return nil
+	})
+	require.NoError(t, err)
+	_, err = client.UpdateSchedule(schedule.ID, ScheduleRequest{
+		Urls:      []string{"/cosmos.staking.v1beta1.MsgDelegate"},
+		StartTime: &start,
+		EndTime:   &later,
+	})
+	require.ErrorContains(t, err, "409")
+	_, err = client.DeleteSchedule(schedule.ID)
+	require.ErrorContains(t, err, "409")
+
+	// windows being applied can't be changed until their result is recorded
+	current, ok := api.schedules.get(schedule.ID)
+	require.True(t, ok)
+	require.True(t, api.schedules.begin(current))
+	require.False(t, api.schedules.begin(current))
+	_, err = client.UpdateSchedule(schedule.ID, ScheduleRequest{
+		Urls:      []string{"/cosmos.bank.v1beta1.MsgSend"},
+		StartTime: &start,
+		EndTime:   &end,
+	})
+	require.ErrorContains(t, err, "409")
+	_, err = client.DeleteSchedule(schedule.ID)
+	require.ErrorContains(t, err, "409")
+	api.setScheduleResult(schedule.ID, SCHEDULE_COMPLETED, "ABC", nil, "")
+	current, ok = api.schedules.get(schedule.ID)
+	require.True(t, ok)
+	require.Equal(t, SCHEDULE_COMPLETED, current.Status)
+	require.False(t, current.applying)
+	_, err = client.DeleteSchedule(schedule.ID)
+	require.NoError(t, err)
+	_, err = client.Schedule(schedule.ID)
+	require.ErrorContains(t, err, "404")
+}
+
+func TestScheduleTrippedUrls(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schedules.json")
+	api := &API{logger: zap.NewNop()}
+	var err error
+	api.schedules, err = openScheduleStore(path)
+	require.NoError(t, err)
+	start, end := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	schedule, err := api.schedules.create(ScheduleRequest{
+		Urls:      []string{"/cosmos.bank.v1beta1.MsgSend", "/cosmos.staking.v1beta1.MsgDelegate"},
+		StartTime: &start,
+		EndTime:   &end,
+	}, Identity{Name: "operator"})
+	require.NoError(t, err)
+
+	// MsgSend was tripped during an incident before the window started, so is not tripped by the window
+	disabled := []string{"/cosmos.bank.v1beta1.MsgSend"}
+	tripped := filterPending(MODE_TRIP, schedule.operationUrls(MODE_TRIP), disabled)
+	require.Equal(t, []string{"/cosmos.staking.v1beta1.MsgDelegate"}, tripped)
+	api.setScheduleResult(schedule.ID, SCHEDULE_ACTIVE, "ABC", tripped, "")
+	schedule, ok := api.schedules.get(schedule.ID)
+	require.True(t, ok)
+	require.Equal(t, tripped, schedule.TrippedUrls)
+
+	// only the circuits tripped by the window are reset at its end
+	disabled = append(disabled, tripped...)
+	require.Equal(t, []string{"/cosmos.staking.v1beta1.MsgDelegate"}, filterPending(MODE_RESET, schedule.operationUrls(MODE_RESET), disabled))
+
+	// a window which found every circuit already tripped resets none of them, including after a restart
+	api.setScheduleResult(schedule.ID, SCHEDULE_ACTIVE, "", nil, "")
+	persisted, err := openScheduleStore(path)
+	require.NoError(t, err)
+	schedule, _ = persisted.get(schedule.ID)
+	require.NotNil(t, schedule.TrippedUrls)
+	require.Empty(t, schedule.operationUrls(MODE_RESET))
+
+	// windows activated before tripped urls were recorded reset every url
+	schedule.TrippedUrls = nil
+	require.Equal(t, schedule.Urls, schedule.operationUrls(MODE_RESET))
+}
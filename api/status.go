@@ -1,14 +1,23 @@
 package api
 
 import (
-	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"net/http"
-	"time"
+	"net/url"
+	"strconv"
 
+	"github.com/cosmos/cosmos-sdk/types/query"
+	"github.com/go-chi/chi/v5"
+	"github.com/teamscanworks/breaker/breakerclient"
 	"go.uber.org/zap"
 )
 
 // Returns types.DisabledListResponse containing all module request urls that have been disabled.
+//
+// Responses of all status calls are encoded as json, unless binary protobuf is requested by
+// sending `Accept: application/x-protobuf`.
 func (api *API) ListDisabledCommands(w http.ResponseWriter, r *http.Request) {
 	if api.breakerClient == nil {
 		http.Error(w, "no initialized breaker client", http.StatusInternalServerError)
@@ -20,32 +29,100 @@ func (api *API) ListDisabledCommands(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "failed to list disabled commands", http.StatusInternalServerError)
 		return
 	}
-	data, err := res.Marshal()
-	if err != nil {
-		api.logger.Error("failed to marshal response", zap.Error(err))
-		http.Error(w, "failed to list accounts", http.StatusInternalServerError)
-		return
-	}
-	http.ServeContent(w, r, "", time.Now(), bytes.NewReader(data))
+	api.serveProto(w, r, res)
 }
 
-// Returns types.AccountsResponse containing all accounts and their corresponding permission levels
+// Returns types.AccountsResponse containing a page of accounts and their corresponding permission levels.
+// Pagination is controlled with the `limit`, `key`, `offset`, `count_total` and `reverse` query parameters,
+// where `key` is the base64 encoded `pagination.next_key` of a previous response.
 func (api *API) ListAccounts(w http.ResponseWriter, r *http.Request) {
 	if api.breakerClient == nil {
 		http.Error(w, "no initialized breaker client", http.StatusInternalServerError)
 		return
 	}
-	res, err := api.breakerClient.Accounts(r.Context())
+	page, err := parsePageRequest(r.URL.Query())
 	if err != nil {
-		api.logger.Error("failed to list accounts", zap.Error(err))
-		http.Error(w, "failed to list accounts", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	data, err := res.Marshal()
+	res, err := api.breakerClient.Accounts(r.Context(), page)
 	if err != nil {
-		api.logger.Error("failed to marshal response", zap.Error(err))
+		api.logger.Error("failed to list accounts", zap.Error(err))
 		http.Error(w, "failed to list accounts", http.StatusInternalServerError)
 		return
 	}
-	http.ServeContent(w, r, "", time.Now(), bytes.NewReader(data))
+	api.serveProto(w, r, res)
+}
+
+// Returns types.AccountResponse containing the permissions granted to the account given by the `address`
+// url parameter. If the account holds no permissions a 404 Not Found response is sent.
+func (api *API) GetAccount(w http.ResponseWriter, r *http.Request) {
+	if api.breakerClient == nil {
+		http.Error(w, "no initialized breaker client", http.StatusInternalServerError)
+		return
+	}
+	address := chi.URLParam(r, "address")
+	if _, err := api.breakerClient.Client.DecodeBech32AccAddr(address); err != nil {
+		http.Error(w, fmt.Sprintf("invalid address %s", err), http.StatusBadRequest)
+		return
+	}
+	res, err := api.breakerClient.Account(r.Context(), address)
+	if errors.Is(err, breakerclient.ErrNoPermissions) {
+		http.Error(w, fmt.Sprintf("account %s has no permissions", address), http.StatusNotFound)
+		return
+	} else if err != nil {
+		api.logger.Error("failed to get account", zap.String("address", address), zap.Error(err))
+		http.Error(w, "failed to get account", http.StatusInternalServerError)
+		return
+	}
+	api.serveProto(w, r, res)
+}
+
+// Parses pagination query parameters, returning nil if none are set so the module defaults are used.
+func parsePageRequest(values url.Values) (*query.PageRequest, error) {
+	var (
+		page query.PageRequest
+		set  bool
+		err  error
+	)
+	if v := values.Get("limit"); v != "" {
+		if page.Limit, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid limit %s", err)
+		}
+		set = true
+	}
+	if v := values.Get("offset"); v != "" {
+		if page.Offset, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid offset %s", err)
+		}
+		set = true
+	}
+	if v := values.Get("key"); v != "" {
+		if page.Key, err = base64.StdEncoding.DecodeString(v); err != nil {
+			// allow keys that were encoded for usage in urls
+			if page.Key, err = base64.URLEncoding.DecodeString(v); err != nil {
+				return nil, fmt.Errorf("invalid key %s", err)
+			}
+		}
+		set = true
+	}
+	if v := values.Get("count_total"); v != "" {
+		if page.CountTotal, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid count_total %s", err)
+		}
+		set = true
+	}
+	if v := values.Get("reverse"); v != "" {
+		if page.Reverse, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid reverse %s", err)
+		}
+		set = true
+	}
+	if page.Key != nil && page.Offset > 0 {
+		return nil, fmt.Errorf("only one of key or offset may be set")
+	}
+	if !set {
+		return nil, nil
+	}
+	return &page, nil
 }
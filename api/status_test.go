@@ -0,0 +1,41 @@
+package api
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/cosmos/cosmos-sdk/types/query"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePageRequest(t *testing.T) {
+	type test struct {
+		name    string
+		query   string
+		want    *query.PageRequest
+		wantErr bool
+	}
+	tests := []test{
+		{name: "empty", query: "", want: nil},
+		{name: "limit", query: "limit=10", want: &query.PageRequest{Limit: 10}},
+		{name: "key", query: "key=AQID&limit=5", want: &query.PageRequest{Key: []byte{1, 2, 3}, Limit: 5}},
+		{name: "offset_count_total", query: "offset=20&count_total=true", want: &query.PageRequest{Offset: 20, CountTotal: true}},
+		{name: "reverse", query: "reverse=true", want: &query.PageRequest{Reverse: true}},
+		{name: "invalid_limit", query: "limit=-1", wantErr: true},
+		{name: "invalid_key", query: "key=***", wantErr: true},
+		{name: "key_and_offset", query: "key=AQID&offset=1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			require.NoError(t, err)
+			page, err := parsePageRequest(values)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, page)
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// A token issued by JWT.Encode, or the jti of a token which has been revoked
+type IssuedToken struct {
+	JTI        string    `json:"jti"`
+	Identifier string    `json:"identifier,omitempty"`
+	Scopes     []string  `json:"scopes,omitempty"`
+	IssuedAt   time.Time `json:"issued_at,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at,omitempty"`
+	Revoked    bool      `json:"revoked"`
+	RevokedAt  time.Time `json:"revoked_at,omitempty"`
+}
+
+// Returns true if the token has a known expiry which has passed
+func (it *IssuedToken) Expired(now time.Time) bool {
+	return !it.ExpiresAt.IsZero() && now.After(it.ExpiresAt)
+}
+
+// Persists issued tokens and revoked token ids as a json file. The file is shared between the api server,
+// which only reads it, and the cli which records issued tokens and revocations. Short lived tokens issued by
+// the api server are not recorded, as writes from separate processes could overwrite each other. Changes made
+// by other processes are picked up by reloading the file whenever its modification time changes.
+type TokenStore struct {
+	path string
+
+	mu      sync.Mutex
+	tokens  map[string]*IssuedToken
+	modTime time.Time
+	size    int64
+}
+
+// Opens the token store persisted at `path`, which is created on the first write if it doesn't exist.
+func OpenTokenStore(path string) (*TokenStore, error) {
+	ts := &TokenStore{
+		path:   path,
+		tokens: make(map[string]*IssuedToken),
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if err := ts.reload(); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// Records a newly issued token
+func (ts *TokenStore) Record(token IssuedToken) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if err := ts.reload(); err != nil {
+		return err
+	}
+	ts.tokens[token.JTI] = &token
+	return ts.save()
+}
+
+// Revokes the token with the given jti. Tokens which were not recorded by this store, such as
+// those issued on another host, may still be revoked.
+func (ts *TokenStore) Revoke(jti string) error {
+	if jti == "" {
+		return fmt.Errorf("jti must not be empty")
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if err := ts.reload(); err != nil {
+		return err
+	}
+	token, ok := ts.tokens[jti]
+	if !ok {
+		token = &IssuedToken{JTI: jti}
+		ts.tokens[jti] = token
+	}
+	token.Revoked = true
+	token.RevokedAt = time.Now().UTC()
+	return ts.save()
+}
+
+// Returns true if the token with the given jti has been revoked. If the store can't be
+// reloaded the previously loaded revocations are used.
+func (ts *TokenStore) IsRevoked(jti string) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	// a failed reload keeps the existing state, as rejecting every token would lock out all callers
+	_ = ts.reload()
+	token, ok := ts.tokens[jti]
+	return ok && token.Revoked
+}
+
+// Returns all tokens in the store
+func (ts *TokenStore) Tokens() ([]IssuedToken, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if err := ts.reload(); err != nil {
+		return nil, err
+	}
+	tokens := make([]IssuedToken, 0, len(ts.tokens))
+	for _, token := range ts.tokens {
+		tokens = append(tokens, *token)
+	}
+	return tokens, nil
+}
+
+// reloads the store if the file has been modified since it was last read, must be called with the lock held
+func (ts *TokenStore) reload() error {
+	info, err := os.Stat(ts.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to stat token store %s", err)
+	}
+	if info.ModTime().Equal(ts.modTime) && info.Size() == ts.size {
+		return nil
+	}
+	data, err := os.ReadFile(ts.path)
+	if err != nil {
+		return fmt.Errorf("failed to read token store %s", err)
+	}
+	var tokens []IssuedToken
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &tokens); err != nil {
+			return fmt.Errorf("failed to parse token store %s", err)
+		}
+	}
+	ts.tokens = make(map[string]*IssuedToken, len(tokens))
+	for i := range tokens {
+		ts.tokens[tokens[i].JTI] = &tokens[i]
+	}
+	ts.modTime = info.ModTime()
+	ts.size = info.Size()
+	return nil
+}
+
+// atomically writes the store to disk, removing expired tokens. must be called with the lock held
+func (ts *TokenStore) save() error {
+	now := time.Now()
+	tokens := make([]IssuedToken, 0, len(ts.tokens))
+	for jti, token := range ts.tokens {
+		if token.Expired(now) {
+			delete(ts.tokens, jti)
+			continue
+		}
+		tokens = append(tokens, *token)
+	}
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize token store %s", err)
+	}
+	if err := writeFileAtomic(ts.path, data); err != nil {
+		return fmt.Errorf("failed to write token store %s", err)
+	}
+	if info, err := os.Stat(ts.path); err == nil {
+		ts.modTime = info.ModTime()
+		ts.size = info.Size()
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package api_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/teamscanworks/breaker/api"
+)
+
+func TestTokenStore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "tokens.json")
+
+	serverTokens, err := api.OpenTokenStore(path)
+	require.NoError(t, err)
+	jwt := api.NewJWT("password123", "user_id", 300)
+	jwt.SetTokenStore(serverTokens)
+
+	encoded, err := jwt.Encode("validUser", map[string]interface{}{
+		api.SCOPES_CLAIM: []string{api.SCOPE_CIRCUIT_TRIP},
+	})
+	require.NoError(t, err)
+	other, err := jwt.Encode("otherUser", nil)
+	require.NoError(t, err)
+
+	token, err := jwt.Decode(ctx, encoded)
+	require.NoError(t, err)
+	require.NotEmpty(t, token.JwtID())
+	require.NoError(t, jwt.CheckToken(token))
+	otherToken, err := jwt.Decode(ctx, other)
+	require.NoError(t, err)
+	require.NotEqual(t, token.JwtID(), otherToken.JwtID())
+
+	// revoke the token from another process, as the cli does
+	cliTokens, err := api.OpenTokenStore(path)
+	require.NoError(t, err)
+	issued, err := cliTokens.Tokens()
+	require.NoError(t, err)
+	require.Len(t, issued, 2)
+	require.NoError(t, cliTokens.Revoke(token.JwtID()))
+
+	require.Error(t, jwt.CheckToken(token))
+	require.NoError(t, jwt.CheckToken(otherToken))
+
+	// tokens issued elsewhere may be revoked by jti
+	require.NoError(t, cliTokens.Revoke("unknown-jti"))
+	require.True(t, serverTokens.IsRevoked("unknown-jti"))
+	require.Error(t, cliTokens.Revoke(""))
+}
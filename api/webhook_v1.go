@@ -1,13 +1,16 @@
 package api
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/teamscanworks/breaker/breakerclient"
 	"go.uber.org/zap"
 )
 
@@ -19,14 +22,38 @@ const (
 	MODE_RESET
 )
 
+// Returns a human readable name of the operation
+func (m Mode) String() string {
+	switch m {
+	case MODE_TRIP:
+		return "trip"
+	case MODE_RESET:
+		return "reset"
+	default:
+		return fmt.Sprintf("unsupported(%d)", int(m))
+	}
+}
+
 // The payload that can be sent through the v1 webhook API
 type PayloadV1 struct {
 	// message that is logged, should be the reason for tripping a circuit
 	Message string
 	// module request urls that we want to apply some action to
 	Urls []string
+	// names of configured circuit groups, whose urls are applied in addition to Urls
+	Groups []string `json:",omitempty"`
 	// the operation being applied against the circuit breaker module
 	Operation Mode
+	// if true, waits for the transaction to be committed and includes the execution
+	// result in the response. this is always enabled when `api.wait_for_commit` is set
+	WaitForCommit bool
+	// if set when tripping circuits, the circuits are automatically reset after this many seconds
+	TTL int64 `json:"ttl,omitempty"`
+	// if set when tripping circuits, the circuits are automatically reset at this time
+	ResetAt *time.Time `json:"reset_at,omitempty"`
+	// if true, the transaction is simulated to report the estimated gas instead of being broadcast.
+	// this is always enabled when `api.dry_run` is set
+	DryRun bool `json:"dry_run,omitempty"`
 }
 
 // A response returned from all webhook calls
@@ -39,81 +66,259 @@ type Response struct {
 	TxHash string
 	// The operation that was applied to the circuit breaker
 	Operation Mode
+	// The following fields are only set when waiting for the transaction to be committed
+
+	// height of the block the transaction was included in
+	Height int64
+	// execution result code, where any non zero value indicates the transaction failed
+	Code      uint32
+	Codespace string
+	RawLog    string
+	GasUsed   int64
+	// fee paid by the transaction
+	Fee string
+	// id of the proposal created when the operation requires approval, in which case no
+	// transaction is sent until the proposal is approved via `/v1/approvals/{id}/approve`
+	ProposalID string `json:",omitempty"`
+	// automatic reset scheduled after tripping circuits with a ttl or reset time
+	ScheduledReset *ScheduledReset `json:",omitempty"`
+	// set when the transaction was simulated instead of broadcast, in which case GasUsed is the gas
+	// consumed during simulation, and GasEstimate the gas limit the transaction would request
+	DryRun      bool   `json:",omitempty"`
+	GasEstimate uint64 `json:",omitempty"`
+	// glob patterns given in the payload, which were expanded into Urls
+	Patterns []string `json:",omitempty"`
+	// urls of each circuit group referenced by the payload, keyed by group name
+	Groups map[string][]string `json:",omitempty"`
 }
 
 // Function which handles the webhook api call for V1 payloads, and consists of
 // deserializing a PayloadV1 message type. You may specific one of two modes, either
 // tripping or resetting a circuit for a list of module request urls.
+//
+// When the `async=true` query parameter is given the payload is executed in the background, and
+// a 202 Accepted response containing the Job is returned, which may be polled via `/v1/jobs/{id}`.
+//
+// The urls of circuit groups referenced by name are included, and reported per group in the response.
+// Glob patterns such as `/cosmos.bank.v1beta1.*` are expanded against the urls registered by the chain, and the
+// expanded urls are returned in the response. Urls which are not registered by the chain are rejected with a 400 Bad Request response containing an
+// InvalidUrlsResponse, which includes suggestions for each unknown url.
+//
+// When the operation requires approval by a second identity, a proposal is created instead and a 202 Accepted
+// response containing the proposal id is returned, regardless of the `async` query parameter.
+//
+// Every call is recorded in the audit log when it is handled, including rejected calls. The result of jobs and
+// approved proposals is appended as a separate entry referencing the entry recorded for the call.
 func (api *API) HandleWebookV1(w http.ResponseWriter, r *http.Request) {
 	if api.breakerClient == nil {
 		http.Error(w, "no initialized breaker client", http.StatusInternalServerError)
 		return
 	}
 
+	ctx := withAuditIntake(r.Context(), auditIntake{RequestedAt: time.Now().UTC()})
+	var payload PayloadV1
+	reject := func(status int, message string) {
+		api.recordIntake(ctx, payload, &Response{Message: message}, status)
+		http.Error(w, message, status)
+	}
+
 	data, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		reject(http.StatusUnauthorized, err.Error())
 		return
 	}
 
-	var payload PayloadV1
 	if err = json.Unmarshal(data, &payload); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		reject(http.StatusBadRequest, err.Error())
 		return
 	}
 
-	var msg string
-	if payload.Operation == MODE_TRIP {
-		msg = "tripping circuit"
-	} else if payload.Operation == MODE_RESET {
-		msg = "resetting circuit"
-	} else {
-		http.Error(w, "unsupported mode", http.StatusBadRequest)
+	if payload.Operation != MODE_TRIP && payload.Operation != MODE_RESET {
+		reject(http.StatusBadRequest, "unsupported mode")
+		return
+	}
+	if scope := RequiredScope(payload.Operation); !IdentityFromContext(ctx).HasScope(scope) {
+		reject(http.StatusForbidden, fmt.Sprintf("missing required scope %s", scope))
+		return
+	}
+	// the automatic reset runs as the caller, so requires the caller to be allowed to reset
+	if (payload.TTL != 0 || payload.ResetAt != nil) && !IdentityFromContext(ctx).HasScope(SCOPE_CIRCUIT_RESET) {
+		reject(http.StatusForbidden, fmt.Sprintf("missing required scope %s", SCOPE_CIRCUIT_RESET))
+		return
+	}
+	urls, groups, err := api.resolveGroups(payload.Urls, payload.Groups)
+	if err != nil {
+		reject(http.StatusBadRequest, err.Error())
+		return
+	}
+	urls, patterns, err := api.expandUrls(ctx, urls)
+	if err != nil {
+		reject(http.StatusBadRequest, err.Error())
+		return
+	}
+	payload.Urls = urls
+	if api.rejectInvalidUrls(w, r, payload.Urls) {
+		api.recordIntake(ctx, payload, &Response{Message: "unknown module request urls"}, http.StatusBadRequest)
+		return
+	}
+	if err := api.validateAutoReset(payload); err != nil {
+		reject(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	pending, err := api.proposeIfRequired(ctx, payload)
+	if err != nil {
+		api.logger.Error("failed to create proposal", zap.Error(err))
+		reject(http.StatusInternalServerError, err.Error())
+		return
+	} else if pending != nil {
+		pending.Patterns = patterns
+		pending.Groups = groups
+		api.writeJSON(w, http.StatusAccepted, pending)
+		return
+	}
+
+	if async, _ := strconv.ParseBool(r.URL.Query().Get("async")); async {
+		job, err := api.jobs.submit(payload, IdentityFromContext(ctx), middleware.GetReqID(ctx), func(job Job) auditIntake {
+			return api.recordIntake(withJobID(ctx, job.ID), payload, &Response{Message: string(JOB_QUEUED)}, http.StatusAccepted)
+		})
+		if err != nil {
+			api.logger.Error("failed to submit job", zap.Error(err))
+			reject(http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		api.logger.Info("queued job", zap.String("job.id", job.ID), zap.Any("urls", payload.Urls), zap.Stringer("operation", payload.Operation))
+		api.writeJSON(w, http.StatusAccepted, &job)
 		return
 	}
 
-	var response Response
+	response := api.executePayload(ctx, payload, nil)
+	response.Patterns = patterns
+	response.Groups = groups
+	api.serveJSON(w, r, &response)
+}
+
+// Applies the operation described by `payload` against the circuit breaker module, optionally
+// waiting for the transaction to be committed. The payload operation must have been validated.
+// If not nil, `onBroadcast` is called with the transaction hash once the transaction is broadcast.
+func (api *API) executePayload(ctx context.Context, payload PayloadV1, onBroadcast func(txHash string)) Response {
+	requestedAt := time.Now().UTC()
+	response := Response{
+		Urls:      payload.Urls,
+		Operation: payload.Operation,
+	}
+	// every execution is recorded in the audit log, including failures
+	defer func() {
+		api.recordAudit(ctx, payload, &response, requestedAt)
+	}()
+	if api.dryRun || payload.DryRun {
+		api.simulatePayload(ctx, payload, &response)
+		return response
+	}
+	var (
+		tx  string
+		err error
+	)
+	// expected before broadcasting, as the change may be observed before the transaction is confirmed
+	api.drift.expect(payload.Operation, payload.Urls)
 	if payload.Operation == MODE_TRIP {
-		if tx, err := api.breakerClient.TripCircuitBreaker(r.Context(), payload.Urls); err != nil {
-			response = Response{
-				Message:   fmt.Sprintf("failed to trip circuit breaker %s", err),
-				Urls:      payload.Urls,
-				Operation: payload.Operation,
-			}
-			api.logger.Error("failed to trip circuit breaker", zap.Any("urls", payload.Urls), zap.Error(err))
-		} else {
-			response = Response{
-				Message:   "ok",
-				Urls:      payload.Urls,
-				Operation: payload.Operation,
-				TxHash:    tx,
+		tx, err = api.breakerClient.TripCircuitBreaker(ctx, payload.Urls)
+	} else {
+		tx, err = api.breakerClient.ResetCircuitBreaker(ctx, payload.Urls)
+	}
+	if err != nil {
+		api.drift.forget(payload.Operation, payload.Urls)
+		response.Message = fmt.Sprintf("failed to %s circuit breaker %s", payload.Operation, err)
+		api.logger.Error(fmt.Sprintf("failed to %s circuit breaker", payload.Operation), zap.Any("urls", payload.Urls), zap.String("message", payload.Message), zap.Error(err))
+		return response
+	}
+	response.TxHash = tx
+	if onBroadcast != nil {
+		onBroadcast(tx)
+	}
+	trip, reset := payload.Urls, []string(nil)
+	if payload.Operation == MODE_RESET {
+		trip, reset = nil, payload.Urls
+	}
+
+	if api.waitForCommit || payload.WaitForCommit {
+		result, err := api.breakerClient.WaitForTx(ctx, tx, api.commitTimeout)
+		api.forgetFailedTx(tx, result, trip, reset)
+		if err != nil {
+			response.Message = fmt.Sprintf("failed to confirm transaction %s", err)
+			api.logger.Error("failed to confirm transaction", zap.String("tx.hash", tx), zap.Error(err))
+			// the transaction may still be committed, in which case the trip must not outlive its ttl.
+			// resets of circuits which were not tripped are skipped when applied
+			if payload.Operation == MODE_TRIP {
+				response.ScheduledReset = api.scheduleReset(ctx, payload, tx)
 			}
-			api.logger.Info("tripped circuit", zap.Any("urls", payload.Urls), zap.String("message", msg))
+			return response
 		}
-	} else if payload.Operation == MODE_RESET {
-		if tx, err := api.breakerClient.ResetCircuitBreaker(r.Context(), payload.Urls); err != nil {
-			response = Response{
-				Message:   fmt.Sprintf("failed to reset circuit breaker %s", err),
-				Urls:      payload.Urls,
-				Operation: payload.Operation,
-			}
-			api.logger.Error("failed to trip circuit breaker", zap.Any("urls", payload.Urls), zap.Error(err))
-		} else {
-			response = Response{
-				Message:   "ok",
-				Urls:      payload.Urls,
-				Operation: payload.Operation,
-				TxHash:    tx,
-			}
-			api.logger.Info("reset circuit", zap.Any("urls", payload.Urls), zap.String("message", msg))
+		response.setTxResult(result)
+		if result.Failed() {
+			response.Message = fmt.Sprintf("transaction failed with code %v (codespace %s): %s", result.Code, result.Codespace, result.RawLog)
+			api.logger.Error("transaction failed",
+				zap.String("tx.hash", tx),
+				zap.Any("urls", payload.Urls),
+				zap.Uint32("code", result.Code),
+				zap.String("codespace", result.Codespace),
+				zap.String("raw.log", result.RawLog),
+			)
+			return response
 		}
+	} else {
+		api.forgetFailedTx(tx, nil, trip, reset)
 	}
 
-	rBytes, err := json.Marshal(&response)
+	response.Message = "ok"
+	if payload.Operation == MODE_TRIP {
+		response.ScheduledReset = api.scheduleReset(ctx, payload, tx)
+	}
+	identity := IdentityFromContext(ctx)
+	if payload.Operation == MODE_TRIP {
+		api.logger.Info("tripped circuit", zap.Any("urls", payload.Urls), zap.String("message", payload.Message), zap.String("tx.hash", tx), zap.String("identity", identity.Name), zap.String("auth.method", identity.Method))
+	} else {
+		api.logger.Info("reset circuit", zap.Any("urls", payload.Urls), zap.String("message", payload.Message), zap.String("tx.hash", tx), zap.String("identity", identity.Name), zap.String("auth.method", identity.Method))
+	}
+	return response
+}
+
+// Simulates the transaction which would apply `payload`, reporting the estimated gas or the simulation error in `response`
+func (api *API) simulatePayload(ctx context.Context, payload PayloadV1, response *Response) {
+	response.DryRun = true
+	var (
+		result *breakerclient.SimulationResult
+		err    error
+	)
+	if payload.Operation == MODE_TRIP {
+		result, err = api.breakerClient.SimulateTripCircuitBreaker(ctx, payload.Urls)
+	} else {
+		result, err = api.breakerClient.SimulateResetCircuitBreaker(ctx, payload.Urls)
+	}
 	if err != nil {
-		api.logger.Error("failed to serialize response", zap.Error(err))
-		http.Error(w, "failed to serialize response", http.StatusInternalServerError)
+		response.Message = fmt.Sprintf("failed to simulate %s %s", payload.Operation, err)
+		api.logger.Error("failed to simulate transaction", zap.Stringer("operation", payload.Operation), zap.Any("urls", payload.Urls), zap.Error(err))
 		return
 	}
-	http.ServeContent(w, r, "", time.Now(), bytes.NewReader(rBytes))
+	response.Message = "ok"
+	response.GasUsed = int64(result.GasUsed)
+	response.GasEstimate = result.GasEstimate
+	identity := IdentityFromContext(ctx)
+	api.logger.Info("simulated transaction",
+		zap.Stringer("operation", payload.Operation),
+		zap.Any("urls", payload.Urls),
+		zap.String("message", payload.Message),
+		zap.Uint64("gas.estimate", result.GasEstimate),
+		zap.String("identity", identity.Name),
+	)
+}
+
+// Copies the execution result of a committed transaction into the response
+func (r *Response) setTxResult(result *breakerclient.TxResult) {
+	r.Height = result.Height
+	r.Code = result.Code
+	r.Codespace = result.Codespace
+	r.RawLog = result.RawLog
+	r.GasUsed = result.GasUsed
+	r.Fee = result.Fee
 }
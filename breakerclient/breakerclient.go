@@ -2,16 +2,31 @@ package breakerclient
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 
+	"cosmossdk.io/collections"
 	"cosmossdk.io/x/circuit"
 	"cosmossdk.io/x/circuit/types"
-	"github.com/cosmos/cosmos-sdk/client"
+	"github.com/cosmos/cosmos-sdk/client/grpc/cmtservice"
 	"github.com/cosmos/cosmos-sdk/crypto/keyring"
+	cryptotypes "github.com/cosmos/cosmos-sdk/crypto/types"
+	reflection "github.com/cosmos/cosmos-sdk/server/grpc/reflection/v2alpha1"
 	sdktypes "github.com/cosmos/cosmos-sdk/types"
-	"github.com/spf13/pflag"
+	"github.com/cosmos/cosmos-sdk/types/query"
+	"github.com/cosmos/cosmos-sdk/types/tx/signing"
 	compass "github.com/teamscanworks/compass"
 	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// returned when looking up an account which holds no permissions with the circuit breaker module
+	ErrNoPermissions = errors.New("account has no permissions")
 )
 
 // cosmos client that interacts with the x/circuit module, wrapping the compass client
@@ -34,11 +49,19 @@ import (
 // ````
 type BreakerClient struct {
 	Client   *compass.Client
-	flagSet  *pflag.FlagSet
 	log      *zap.Logger
 	qc       types.QueryClient
 	ctx      context.Context
 	cancelFn context.CancelFunc
+	// multiplied with the gas used by simulated transactions to estimate their gas limit
+	gasAdjustment float64
+	// used when building transactions which compass can't send, such as those with multiple messages
+	chainID   string
+	gasPrices string
+	// serializes every transaction sent by the breaker client, so they don't reuse a sequence number
+	txLock sync.Mutex
+	// hash of the last transaction broadcast by UpdateCircuits, which may not be committed yet. guarded by txLock
+	pendingTx string
 }
 
 // Wraps the compass client with additional functionality specific to the x/circuit module.
@@ -61,12 +84,14 @@ func NewBreakerClient(
 	qc := types.NewQueryClient(cl.GRPC)
 
 	bc := &BreakerClient{
-		ctx:      ctx,
-		cancelFn: cancel,
-		Client:   cl,
-		qc:       qc,
-		flagSet:  pflag.NewFlagSet("", pflag.ExitOnError),
-		log:      log.Named("breaker.client"),
+		ctx:           ctx,
+		cancelFn:      cancel,
+		Client:        cl,
+		qc:            qc,
+		log:           log.Named("breaker.client"),
+		gasAdjustment: cfg.GasAdjustment,
+		chainID:       cfg.ChainID,
+		gasPrices:     cfg.GasPrices,
 	}
 	return bc, nil
 }
@@ -77,22 +102,77 @@ func (bc *BreakerClient) GetActiveKeypair() (*sdktypes.AccAddress, error) {
 	return bc.Client.GetActiveKeypair()
 }
 
+// Returns the height of the latest block.
+func (bc *BreakerClient) LatestHeight(ctx context.Context) (int64, error) {
+	res, err := cmtservice.NewServiceClient(bc.Client.GRPC).GetLatestBlock(ctx, &cmtservice.GetLatestBlockRequest{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to query latest block %s", err)
+	}
+	if res.SdkBlock != nil {
+		return res.SdkBlock.Header.Height, nil
+	}
+	if res.Block != nil {
+		return res.Block.Header.Height, nil
+	}
+	return 0, fmt.Errorf("latest block response contains no block")
+}
+
+// Lists the type urls of all messages registered by the chain, as reported by the reflection service.
+func (bc *BreakerClient) ListMessages(ctx context.Context) ([]string, error) {
+	res, err := reflection.NewReflectionServiceClient(bc.Client.GRPC).GetTxDescriptor(ctx, &reflection.GetTxDescriptorRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tx descriptor %s", err)
+	}
+	if res.Tx == nil {
+		return nil, fmt.Errorf("tx descriptor response contains no descriptor")
+	}
+	urls := make([]string, 0, len(res.Tx.Msgs))
+	for _, msg := range res.Tx.Msgs {
+		urls = append(urls, msg.MsgTypeUrl)
+	}
+	sort.Strings(urls)
+	return urls, nil
+}
+
 // Lists commands/urls that have had their circuits tripped.
 func (bc *BreakerClient) ListDisabledCommands(ctx context.Context) (*types.DisabledListResponse, error) {
 	return bc.qc.DisabledList(ctx, &types.QueryDisabledListRequest{})
 }
 
-// List permissions granted to the given address.
+// List permissions granted to the given address. If the address has never been granted
+// permissions, or they have since been revoked, ErrNoPermissions is returned.
 func (bc *BreakerClient) Account(ctx context.Context, address string) (*types.AccountResponse, error) {
-	return bc.qc.Account(ctx, &types.QueryAccountRequest{Address: address})
-}
-
-// Returns a paginated list of all accounts that have permissions granted to them.
-func (bc *BreakerClient) Accounts(ctx context.Context) (*types.AccountsResponse, error) {
-	page, err := client.ReadPageRequest(bc.flagSet)
+	res, err := bc.qc.Account(ctx, &types.QueryAccountRequest{Address: address})
 	if err != nil {
+		if isNotFound(err) {
+			return nil, ErrNoPermissions
+		}
 		return nil, err
 	}
+	if res.Permission == nil || res.Permission.Level == types.Permissions_LEVEL_NONE_UNSPECIFIED {
+		return nil, ErrNoPermissions
+	}
+	return res, nil
+}
+
+// Returns true if `err` is the not found error returned by the module for accounts without permissions. The module
+// returns the collections not found error, which isn't registered with a grpc status code and so is received as
+// an unknown status whose message starts with the error. Any other error, including transport errors, is false.
+func isNotFound(err error) bool {
+	if errors.Is(err, collections.ErrNotFound) {
+		return true
+	}
+	s, ok := status.FromError(err)
+	if !ok {
+		return false
+	}
+	return s.Code() == codes.NotFound || (s.Code() == codes.Unknown && strings.HasPrefix(s.Message(), collections.ErrNotFound.Error()))
+}
+
+// Returns a paginated list of all accounts that have permissions granted to them. If `page` is nil
+// the first page is returned using the module's default page size, and `Pagination.NextKey` of
+// the response may be used to request the following page.
+func (bc *BreakerClient) Accounts(ctx context.Context, page *query.PageRequest) (*types.AccountsResponse, error) {
 	return bc.qc.Accounts(ctx, &types.QueryAccountsRequest{Pagination: page})
 }
 
@@ -108,6 +188,9 @@ func (bc *BreakerClient) Authorize(ctx context.Context, grantee string, permissi
 	}
 	granterAddr := bc.Client.FromAddress()
 	msg := types.NewMsgAuthorizeCircuitBreaker(granterAddr, grantee, &permission)
+	bc.txLock.Lock()
+	defer bc.txLock.Unlock()
+	bc.awaitPendingTx(ctx)
 	if tx, err := bc.Client.SendTransaction(ctx, msg); err != nil {
 		bc.log.Error("failed to send transaction", zap.Stack("stack.trace"))
 		return "", err
@@ -116,10 +199,24 @@ func (bc *BreakerClient) Authorize(ctx context.Context, grantee string, permissi
 	}
 }
 
+// Revokes all permissions previously granted to the given account. The x/circuit module
+// has no dedicated revocation message, so this authorizes the grantee with `LEVEL_NONE_UNSPECIFIED`.
+func (bc *BreakerClient) Revoke(ctx context.Context, grantee string) (string, error) {
+	return bc.Authorize(ctx, grantee, types.Permissions_LEVEL_NONE_UNSPECIFIED.String(), nil)
+}
+
 // Trip a circuit for the given urls, preventing calls to the module request urls.
+// Glob patterns such as `/cosmos.bank.v1beta1.*` are expanded against the messages registered by the chain.
 func (bc *BreakerClient) TripCircuitBreaker(ctx context.Context, urls []string) (string, error) {
+	urls, err := bc.ExpandUrls(ctx, urls)
+	if err != nil {
+		return "", err
+	}
 	granterAddr := bc.Client.FromAddress()
 	msg := types.NewMsgTripCircuitBreaker(granterAddr, urls)
+	bc.txLock.Lock()
+	defer bc.txLock.Unlock()
+	bc.awaitPendingTx(ctx)
 	if tx, err := bc.Client.SendTransaction(ctx, msg); err != nil {
 		bc.log.Error("failed to send transaction", zap.Stack("stack.trace"))
 		return "", err
@@ -129,9 +226,17 @@ func (bc *BreakerClient) TripCircuitBreaker(ctx context.Context, urls []string)
 }
 
 // Resets a tripped circuit, allowing calls to the module request urls.
+// Glob patterns such as `/cosmos.bank.v1beta1.*` are expanded against the messages registered by the chain.
 func (bc *BreakerClient) ResetCircuitBreaker(ctx context.Context, urls []string) (string, error) {
+	urls, err := bc.ExpandUrls(ctx, urls)
+	if err != nil {
+		return "", err
+	}
 	granterAddr := bc.Client.FromAddress()
 	msg := types.NewMsgResetCircuitBreaker(granterAddr, urls)
+	bc.txLock.Lock()
+	defer bc.txLock.Unlock()
+	bc.awaitPendingTx(ctx)
 	if tx, err := bc.Client.SendTransaction(ctx, msg); err != nil {
 		bc.log.Error("failed to send transaction", zap.Stack("stack.trace"))
 		return "", err
@@ -155,6 +260,15 @@ func (bc *BreakerClient) NewMnemonic(keyName string, mnemonic ...string) (string
 	return keyOutput.Mnemonic, nil
 }
 
+// Signs arbitrary bytes with the key used for signing transactions, returning the signature and the public key of the signer.
+func (bc *BreakerClient) SignBytes(msg []byte) ([]byte, cryptotypes.PubKey, error) {
+	sig, pubKey, err := bc.Client.Keyring.Sign(bc.Client.FromName(), msg, signing.SignMode_SIGN_MODE_DIRECT)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to sign bytes %s", err)
+	}
+	return sig, pubKey, nil
+}
+
 func (bc *BreakerClient) UpdateClientFromName(name string) {
 	bc.Client.UpdateFromName(name)
 }
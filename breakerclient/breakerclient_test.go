@@ -26,6 +26,9 @@ func TestBreakerClient(t *testing.T) {
 	disabledCmds, err := breaker.ListDisabledCommands(ctx)
 	require.NoError(t, err)
 	require.Len(t, disabledCmds.DisabledList, 0)
+	messages, err := breaker.ListMessages(ctx)
+	require.NoError(t, err)
+	require.Contains(t, messages, "/cosmos.bank.v1beta1.MsgSend")
 
 	mnemonic, err := breaker.NewMnemonic("example1")
 	require.NoError(t, err)
@@ -42,3 +45,28 @@ func TestBreakerClient(t *testing.T) {
 	require.NotNil(t, rec)
 
 }
+
+func TestExpandPatterns(t *testing.T) {
+	registered := []string{
+		"/cosmos.bank.v1beta1.MsgMultiSend",
+		"/cosmos.bank.v1beta1.MsgSend",
+		"/cosmos.staking.v1beta1.MsgDelegate",
+		"/cosmos.staking.v1beta1.MsgUndelegate",
+	}
+	require.True(t, breakerclient.IsPattern("/cosmos.bank.v1beta1.*"))
+	require.False(t, breakerclient.IsPattern("/cosmos.bank.v1beta1.MsgSend"))
+
+	urls, err := breakerclient.ExpandPatterns([]string{"/cosmos.bank.v1beta1.*"}, registered)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/cosmos.bank.v1beta1.MsgMultiSend", "/cosmos.bank.v1beta1.MsgSend"}, urls)
+
+	// duplicates are removed, and urls which aren't patterns are kept as is
+	urls, err = breakerclient.ExpandPatterns([]string{"/cosmos.bank.v1beta1.MsgSend", "/cosmos.*.v1beta1.Msg*Send", "/custom.Msg"}, registered)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/cosmos.bank.v1beta1.MsgSend", "/cosmos.bank.v1beta1.MsgMultiSend", "/custom.Msg"}, urls)
+
+	_, err = breakerclient.ExpandPatterns([]string{"/cosmos.gov.v1.*"}, registered)
+	require.ErrorContains(t, err, "matches no registered messages")
+	_, err = breakerclient.ExpandPatterns([]string{"/cosmos.bank.v1beta1.[Msg"}, registered)
+	require.ErrorContains(t, err, "invalid url pattern")
+}
@@ -0,0 +1,69 @@
+package breakerclient
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Returns true if `url` is a glob pattern, such as `/cosmos.bank.v1beta1.*`, rather than a module request url.
+// Patterns use the syntax of path.Match, where `*` matches any sequence of characters other than `/`.
+func IsPattern(url string) bool {
+	return strings.ContainsAny(url, "*?[")
+}
+
+// Replaces each glob pattern in `urls` with the urls in `registered` which it matches, preserving the order of
+// `urls` and removing duplicates. An error is returned if a pattern is malformed, or matches no registered urls,
+// as tripping fewer circuits than intended is worse than failing.
+func ExpandPatterns(urls []string, registered []string) ([]string, error) {
+	seen := make(map[string]bool, len(urls))
+	expanded := make([]string, 0, len(urls))
+	add := func(url string) {
+		if !seen[url] {
+			seen[url] = true
+			expanded = append(expanded, url)
+		}
+	}
+	for _, url := range urls {
+		if !IsPattern(url) {
+			add(url)
+			continue
+		}
+		matched := false
+		for _, candidate := range registered {
+			ok, err := path.Match(url, candidate)
+			if err != nil {
+				return nil, fmt.Errorf("invalid url pattern %s %s", url, err)
+			}
+			if ok {
+				matched = true
+				add(candidate)
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("url pattern %s matches no registered messages", url)
+		}
+	}
+	return expanded, nil
+}
+
+// Expands any glob patterns in `urls` against the messages registered by the chain. If `urls`
+// contains no patterns it is returned as is, without querying the chain.
+func (bc *BreakerClient) ExpandUrls(ctx context.Context, urls []string) ([]string, error) {
+	hasPattern := false
+	for _, url := range urls {
+		if IsPattern(url) {
+			hasPattern = true
+			break
+		}
+	}
+	if !hasPattern {
+		return urls, nil
+	}
+	registered, err := bc.ListMessages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ExpandPatterns(urls, registered)
+}
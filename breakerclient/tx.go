@@ -0,0 +1,266 @@
+package breakerclient
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"cosmossdk.io/x/circuit/types"
+	"github.com/cosmos/cosmos-sdk/client"
+	"github.com/cosmos/cosmos-sdk/client/tx"
+	sdktypes "github.com/cosmos/cosmos-sdk/types"
+	txtypes "github.com/cosmos/cosmos-sdk/types/tx"
+	"github.com/cosmos/cosmos-sdk/types/tx/signing"
+	"go.uber.org/zap"
+)
+
+// maximum time to wait for the previous transaction to be committed before sending another
+const pendingTxTimeout = time.Second * 30
+
+// The result of executing a transaction which has been included in a block
+type TxResult struct {
+	TxHash string
+	// height of the block the transaction was included in
+	Height int64
+	// execution result code, 0 indicates the transaction was successful
+	Code      uint32
+	Codespace string
+	RawLog    string
+	GasWanted int64
+	GasUsed   int64
+	// fee paid by the transaction, formatted as a coin string ex: "200stake"
+	Fee string
+}
+
+// Returns true if the transaction was included in a block, but failed during execution.
+func (tr *TxResult) Failed() bool {
+	return tr.Code != 0
+}
+
+// Waits for the transaction identified by `txHash` to be committed, returning the execution result.
+// Returns an error if the transaction is not found before `timeout` elapses, however a transaction
+// which was committed but failed during execution is not an error, and should be checked with TxResult.Failed.
+func (bc *BreakerClient) WaitForTx(ctx context.Context, txHash string, timeout time.Duration) (*TxResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	sc := txtypes.NewServiceClient(bc.Client.GRPC)
+	checkTicker := time.NewTicker(time.Second)
+	defer checkTicker.Stop()
+	for {
+		res, err := sc.GetTx(ctx, &txtypes.GetTxRequest{Hash: txHash})
+		if err == nil && res.TxResponse != nil {
+			return newTxResult(res), nil
+		}
+		bc.log.Debug("transaction not yet committed", zap.String("tx.hash", txHash), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to confirm transaction %s within %s", txHash, timeout)
+		case <-checkTicker.C:
+		}
+	}
+}
+
+func newTxResult(res *txtypes.GetTxResponse) *TxResult {
+	result := &TxResult{
+		TxHash:    res.TxResponse.TxHash,
+		Height:    res.TxResponse.Height,
+		Code:      res.TxResponse.Code,
+		Codespace: res.TxResponse.Codespace,
+		RawLog:    res.TxResponse.RawLog,
+		GasWanted: res.TxResponse.GasWanted,
+		GasUsed:   res.TxResponse.GasUsed,
+	}
+	if res.Tx != nil && res.Tx.AuthInfo != nil && res.Tx.AuthInfo.Fee != nil {
+		result.Fee = res.Tx.AuthInfo.Fee.Amount.String()
+	}
+	return result
+}
+
+// The result of simulating a transaction without broadcasting it
+type SimulationResult struct {
+	// gas consumed while simulating the transaction
+	GasUsed uint64
+	// gas limit the transaction would request, the gas used multiplied by the configured gas adjustment
+	GasEstimate uint64
+	Log         string
+}
+
+// Simulates tripping the circuit for the given urls, without broadcasting a transaction. An error
+// is returned if the transaction could not be built, or if it would fail during execution.
+func (bc *BreakerClient) SimulateTripCircuitBreaker(ctx context.Context, urls []string) (*SimulationResult, error) {
+	urls, err := bc.ExpandUrls(ctx, urls)
+	if err != nil {
+		return nil, err
+	}
+	return bc.simulate(ctx, types.NewMsgTripCircuitBreaker(bc.Client.FromAddress(), urls))
+}
+
+// Simulates resetting the circuit for the given urls, without broadcasting a transaction. An error
+// is returned if the transaction could not be built, or if it would fail during execution.
+func (bc *BreakerClient) SimulateResetCircuitBreaker(ctx context.Context, urls []string) (*SimulationResult, error) {
+	urls, err := bc.ExpandUrls(ctx, urls)
+	if err != nil {
+		return nil, err
+	}
+	return bc.simulate(ctx, types.NewMsgResetCircuitBreaker(bc.Client.FromAddress(), urls))
+}
+
+// Returns the messages tripping the circuit for `trip` and resetting the circuit for `reset`
+func (bc *BreakerClient) circuitMsgs(trip []string, reset []string) ([]sdktypes.Msg, error) {
+	var msgs []sdktypes.Msg
+	if len(trip) > 0 {
+		msgs = append(msgs, types.NewMsgTripCircuitBreaker(bc.Client.FromAddress(), trip))
+	}
+	if len(reset) > 0 {
+		msgs = append(msgs, types.NewMsgResetCircuitBreaker(bc.Client.FromAddress(), reset))
+	}
+	if len(msgs) == 0 {
+		return nil, fmt.Errorf("no urls to trip or reset")
+	}
+	return msgs, nil
+}
+
+// Trips the circuit for the `trip` urls and resets the circuit for the `reset` urls in a single transaction, so
+// either all changes are applied or none are. Either list may be empty, but not both. Returns the transaction
+// hash once the transaction has been accepted into the mempool, use WaitForTx to wait for it to be committed.
+func (bc *BreakerClient) UpdateCircuits(ctx context.Context, trip []string, reset []string) (string, error) {
+	msgs, err := bc.circuitMsgs(trip, reset)
+	if err != nil {
+		return "", err
+	}
+	bc.txLock.Lock()
+	defer bc.txLock.Unlock()
+	bc.awaitPendingTx(ctx)
+	simulation, err := bc.simulate(ctx, msgs...)
+	if err != nil {
+		return "", err
+	}
+	record, err := bc.Client.Keyring.Key(bc.Client.FromName())
+	if err != nil {
+		return "", fmt.Errorf("failed to load signing key %s", err)
+	}
+	address, err := record.GetAddress()
+	if err != nil {
+		return "", fmt.Errorf("failed to load signing key %s", err)
+	}
+	accountNumber, sequence, err := bc.Client.GetAccountNumberSequence(client.Context{}, address)
+	if err != nil {
+		return "", fmt.Errorf("failed to query account %s", err)
+	}
+	factory := tx.Factory{}.
+		WithTxConfig(bc.Client.Codec.TxConfig).
+		WithKeybase(bc.Client.Keyring).
+		WithChainID(bc.chainID).
+		WithAccountNumber(accountNumber).
+		WithSequence(sequence).
+		WithGas(simulation.GasEstimate).
+		WithGasPrices(bc.gasPrices).
+		WithSignMode(signing.SignMode_SIGN_MODE_DIRECT)
+	builder, err := factory.BuildUnsignedTx(msgs...)
+	if err != nil {
+		return "", fmt.Errorf("failed to build transaction %s", err)
+	}
+	if err := tx.Sign(ctx, factory, bc.Client.FromName(), builder, true); err != nil {
+		return "", fmt.Errorf("failed to sign transaction %s", err)
+	}
+	txBytes, err := bc.Client.Codec.TxConfig.TxEncoder()(builder.GetTx())
+	if err != nil {
+		return "", fmt.Errorf("failed to encode transaction %s", err)
+	}
+	res, err := txtypes.NewServiceClient(bc.Client.GRPC).BroadcastTx(ctx, &txtypes.BroadcastTxRequest{
+		TxBytes: txBytes,
+		Mode:    txtypes.BroadcastMode_BROADCAST_MODE_SYNC,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to broadcast transaction %s", err)
+	}
+	if res.TxResponse == nil {
+		return "", fmt.Errorf("broadcast response contains no result")
+	}
+	if res.TxResponse.Code != 0 {
+		return "", fmt.Errorf("transaction rejected with code %v (codespace %s): %s", res.TxResponse.Code, res.TxResponse.Codespace, res.TxResponse.RawLog)
+	}
+	bc.pendingTx = res.TxResponse.TxHash
+	bc.log.Info("sent transaction", zap.String("tx.hash", res.TxResponse.TxHash), zap.Any("trip", trip), zap.Any("reset", reset))
+	return res.TxResponse.TxHash, nil
+}
+
+// Waits for the transaction last broadcast by UpdateCircuits to be committed. The sequence number of the signing
+// account is queried from committed state, so sending another transaction while it is pending would reuse its
+// sequence number. Transactions sent through compass wait for them to be committed, so need no tracking. Must be
+// called with txLock held.
+func (bc *BreakerClient) awaitPendingTx(ctx context.Context) {
+	if bc.pendingTx == "" {
+		return
+	}
+	if _, err := bc.WaitForTx(ctx, bc.pendingTx, pendingTxTimeout); err != nil {
+		bc.log.Warn("previous transaction was not committed", zap.String("tx.hash", bc.pendingTx), zap.Error(err))
+	}
+	bc.pendingTx = ""
+}
+
+// Simulates the transaction sent by UpdateCircuits, without broadcasting it
+func (bc *BreakerClient) SimulateUpdateCircuits(ctx context.Context, trip []string, reset []string) (*SimulationResult, error) {
+	msgs, err := bc.circuitMsgs(trip, reset)
+	if err != nil {
+		return nil, err
+	}
+	return bc.simulate(ctx, msgs...)
+}
+
+// Builds a transaction containing `msgs` signed with an empty signature, which is sufficient
+// for simulation, and simulates it against the chain.
+func (bc *BreakerClient) simulate(ctx context.Context, msgs ...sdktypes.Msg) (*SimulationResult, error) {
+	record, err := bc.Client.Keyring.Key(bc.Client.FromName())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signing key %s", err)
+	}
+	pubKey, err := record.GetPubKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signing key %s", err)
+	}
+	address, err := record.GetAddress()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signing key %s", err)
+	}
+	_, sequence, err := bc.Client.GetAccountNumberSequence(client.Context{}, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query account %s", err)
+	}
+
+	txConfig := bc.Client.Codec.TxConfig
+	builder := txConfig.NewTxBuilder()
+	if err := builder.SetMsgs(msgs...); err != nil {
+		return nil, fmt.Errorf("failed to build transaction %s", err)
+	}
+	if err := builder.SetSignatures(signing.SignatureV2{
+		PubKey:   pubKey,
+		Data:     &signing.SingleSignatureData{SignMode: signing.SignMode_SIGN_MODE_DIRECT},
+		Sequence: sequence,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to build transaction %s", err)
+	}
+	txBytes, err := txConfig.TxEncoder()(builder.GetTx())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode transaction %s", err)
+	}
+
+	res, err := txtypes.NewServiceClient(bc.Client.GRPC).Simulate(ctx, &txtypes.SimulateRequest{TxBytes: txBytes})
+	if err != nil {
+		return nil, fmt.Errorf("simulation failed %s", err)
+	}
+	result := &SimulationResult{}
+	if res.GasInfo != nil {
+		adjustment := bc.gasAdjustment
+		if adjustment <= 0 {
+			adjustment = 1
+		}
+		result.GasUsed = res.GasInfo.GasUsed
+		result.GasEstimate = uint64(math.Ceil(float64(res.GasInfo.GasUsed) * adjustment))
+	}
+	if res.Result != nil {
+		result.Log = res.Result.Log
+	}
+	return result, nil
+}
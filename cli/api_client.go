@@ -0,0 +1,115 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/teamscanworks/breaker/api"
+	"github.com/teamscanworks/breaker/config"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	// flags used to construct an api client, shared by commands which call the api server
+	apiClientFlags = []cli.Flag{
+		&cli.StringFlag{
+			Name:  "api.url",
+			Usage: "url of the api server, defaults to the api.listen_address of the configuration file",
+		},
+		&cli.StringFlag{
+			Name:    "jwt",
+			Usage:   "jwt used to authenticate with the api server",
+			EnvVars: []string{"BREAKER_JWT"},
+		},
+		&cli.StringFlag{
+			Name:    "api.key",
+			Usage:   "api key exchanged for a jwt, used instead of --jwt",
+			EnvVars: []string{"BREAKER_API_KEY"},
+		},
+	}
+	scheduleIDFlag = &cli.StringFlag{
+		Name:     "id",
+		Usage:    "id of the maintenance window",
+		Required: true,
+	}
+	scheduleFlags = []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "url",
+			Usage: "module request url or glob pattern to trip during the window, may be repeated",
+		},
+		&cli.StringSliceFlag{
+			Name:  "group",
+			Usage: "name of a circuit group defined in the api server's configuration to trip during the window, may be repeated",
+		},
+		&cli.StringFlag{
+			Name:  "message",
+			Usage: "reason for the maintenance window",
+		},
+		&cli.TimestampFlag{
+			Name:   "start",
+			Usage:  "RFC3339 time at which the window starts",
+			Layout: time.RFC3339,
+		},
+		&cli.TimestampFlag{
+			Name:   "end",
+			Usage:  "RFC3339 time at which the window ends",
+			Layout: time.RFC3339,
+		},
+		&cli.Int64Flag{
+			Name:  "start.height",
+			Usage: "block height at which the window starts, used instead of --start",
+		},
+		&cli.Int64Flag{
+			Name:  "end.height",
+			Usage: "block height at which the window ends, used instead of --end",
+		},
+	}
+)
+
+// Returns an api client for the api server given by the `api.url` flag, or the configuration file
+func newAPIClient(cCtx *cli.Context) (*api.APIClient, error) {
+	url := cCtx.String("api.url")
+	if url == "" {
+		cfg, err := config.LoadConfig(cCtx.String("config.path"))
+		if err != nil {
+			return nil, err
+		}
+		scheme := "http"
+		if cfg.API.TLS.CertFile != "" {
+			scheme = "https"
+		}
+		url = fmt.Sprintf("%s://%s", scheme, cfg.API.ListenAddress)
+	}
+	var opts []api.APIClientOption
+	if apiKey := cCtx.String("api.key"); apiKey != "" {
+		opts = append(opts, api.WithAPIKey(apiKey))
+	} else if cCtx.String("jwt") == "" {
+		return nil, fmt.Errorf("either --jwt or --api.key must be given")
+	}
+	client := api.NewAPIClient(url, cCtx.String("jwt"), opts...)
+	return &client, nil
+}
+
+// Returns the maintenance window described by the schedule flags
+func scheduleRequest(cCtx *cli.Context) api.ScheduleRequest {
+	return api.ScheduleRequest{
+		Message:     cCtx.String("message"),
+		Urls:        cCtx.StringSlice("url"),
+		Groups:      cCtx.StringSlice("group"),
+		StartTime:   cCtx.Timestamp("start"),
+		EndTime:     cCtx.Timestamp("end"),
+		StartHeight: cCtx.Int64("start.height"),
+		EndHeight:   cCtx.Int64("end.height"),
+	}
+}
+
+// Prints `v` to stdout as indented json
+func printJSON(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize response %s", err)
+	}
+	fmt.Println(string(data))
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/teamscanworks/breaker/api"
 	"github.com/teamscanworks/breaker/breakerclient"
@@ -50,16 +51,34 @@ func RunCLI() {
 						if err != nil {
 							return err
 						}
-						jwt := api.NewJWT(
-							cfg.API.Password,
-							cfg.API.IdentifierField,
-							cfg.API.TokenValidityDurationSeconds,
-						)
-						tkn, err := jwt.Encode(cCtx.String("identifier.value"), nil)
+						if cfg.API.OIDC.Enabled {
+							return fmt.Errorf("tokens are issued by the oidc provider when api.oidc is enabled")
+						}
+						jwt, err := cfg.JWT(cCtx.Context)
+						if err != nil {
+							return err
+						}
+						scopes := cCtx.StringSlice("scope")
+						if len(scopes) == 0 {
+							scopes = []string{api.SCOPE_CIRCUIT_TRIP, api.SCOPE_CIRCUIT_RESET, api.SCOPE_CIRCUIT_READ}
+						}
+						if cCtx.Bool("admin") {
+							scopes = append(scopes, api.SCOPE_ACCOUNTS_ADMIN)
+						}
+						if err := api.ValidateScopes(scopes); err != nil {
+							return err
+						}
+						tkn, err := jwt.Encode(cCtx.String("identifier.value"), map[string]interface{}{
+							api.SCOPES_CLAIM: scopes,
+						})
 						if err != nil {
 							return err
 						}
-						logger.Info("issued token", zap.String("jwt.token", tkn))
+						decoded, err := jwt.Decode(cCtx.Context, tkn)
+						if err != nil {
+							return err
+						}
+						logger.Info("issued token", zap.String("jwt.token", tkn), zap.String("jwt.id", decoded.JwtID()))
 						return nil
 					},
 					Flags: []cli.Flag{
@@ -67,6 +86,45 @@ func RunCLI() {
 							Name:  "identifier.value",
 							Usage: "value to use as the identifier",
 						},
+						&cli.StringSliceFlag{
+							Name:  "scope",
+							Usage: "scope to grant the token, may be repeated. one of circuit:trip, circuit:reset, circuit:read, accounts:admin. defaults to circuit:trip, circuit:reset, circuit:read",
+						},
+						&cli.BoolFlag{
+							Name:  "admin",
+							Usage: "if present, grants the accounts:admin scope allowing the token to authorize and revoke accounts",
+						},
+					},
+				},
+				{
+					Name:  "revoke-jwt",
+					Usage: "revokes a previously issued jwt, causing the api server to reject it",
+					Action: func(cCtx *cli.Context) error {
+						cfgPath := cCtx.String("config.path")
+						cfg, err := config.LoadConfig(cfgPath)
+						if err != nil {
+							return err
+						}
+						logger, err := cfg.ZapLogger(cCtx.Bool("debug.log"))
+						if err != nil {
+							return err
+						}
+						tokens, err := cfg.TokenStore()
+						if err != nil {
+							return err
+						}
+						if err := tokens.Revoke(cCtx.String("jti")); err != nil {
+							return err
+						}
+						logger.Info("revoked token", zap.String("jwt.id", cCtx.String("jti")))
+						return nil
+					},
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:     "jti",
+							Usage:    "id of the token to revoke, as logged when the token was issued",
+							Required: true,
+						},
 					},
 				},
 				{
@@ -86,11 +144,11 @@ func RunCLI() {
 							cancel()
 							return err
 						}
-						jwt := api.NewJWT(
-							cfg.API.Password,
-							cfg.API.IdentifierField,
-							cfg.API.TokenValidityDurationSeconds,
-						)
+						jwt, err := cfg.JWT(ctx)
+						if err != nil {
+							cancel()
+							return err
+						}
 						bc, err := breakerclient.NewBreakerClient(
 							ctx,
 							logger,
@@ -141,6 +199,233 @@ func RunCLI() {
 				},
 			},
 		},
+		{
+			Name:  "audit",
+			Usage: "audit log management",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "verify",
+					Usage: "verifies the hash chain and signatures of the audit log, reporting the first broken link",
+					Action: func(cCtx *cli.Context) error {
+						cfgPath := cCtx.String("config.path")
+						cfg, err := config.LoadConfig(cfgPath)
+						if err != nil {
+							return err
+						}
+						logger, err := cfg.ZapLogger(cCtx.Bool("debug.log"))
+						if err != nil {
+							return err
+						}
+						path := cCtx.String("audit.path")
+						if path == "" {
+							path = cfg.API.AuditLogPath
+						}
+						if path == "" {
+							return fmt.Errorf("no audit log path configured")
+						}
+						opts := api.AuditVerifyOpts{RequireSignatures: cCtx.Bool("require-signatures")}
+						if keyName := cCtx.String("trusted.key"); keyName != "" {
+							bc, err := breakerclient.NewBreakerClient(cCtx.Context, logger, &cfg.Compass)
+							if err != nil {
+								return err
+							}
+							record, err := bc.Client.Keyring.Key(keyName)
+							if err != nil {
+								return fmt.Errorf("failed to load key %s", err)
+							}
+							if opts.TrustedPubKey, err = record.GetPubKey(); err != nil {
+								return fmt.Errorf("failed to load public key %s", err)
+							}
+						}
+						entries, err := api.ReadAuditLog(path)
+						if err != nil {
+							return err
+						}
+						if err := api.VerifyAuditLog(entries, opts); err != nil {
+							logger.Error("audit log verification failed", zap.String("audit.path", path), zap.Error(err))
+							return err
+						}
+						logger.Info("verified audit log", zap.String("audit.path", path), zap.Int("entries", len(entries)))
+						return nil
+					},
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "audit.path",
+							Usage: "path to the audit log, defaults to api.audit_log_path",
+						},
+						&cli.BoolFlag{
+							Name:  "require-signatures",
+							Usage: "if present, unsigned entries fail verification",
+						},
+						&cli.StringFlag{
+							Name:  "trusted.key",
+							Usage: "name of a keyring key, if set entries signed by any other key fail verification",
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:  "schedule",
+			Usage: "manage maintenance windows through the api server",
+			Flags: apiClientFlags,
+			Subcommands: []*cli.Command{
+				{
+					Name:  "create",
+					Usage: "creates a maintenance window, tripping circuits at its start and resetting them at its end",
+					Flags: scheduleFlags,
+					Action: func(cCtx *cli.Context) error {
+						client, err := newAPIClient(cCtx)
+						if err != nil {
+							return err
+						}
+						schedule, err := client.CreateSchedule(scheduleRequest(cCtx))
+						if err != nil {
+							return err
+						}
+						return printJSON(schedule)
+					},
+				},
+				{
+					Name:  "list",
+					Usage: "lists maintenance windows",
+					Action: func(cCtx *cli.Context) error {
+						client, err := newAPIClient(cCtx)
+						if err != nil {
+							return err
+						}
+						schedules, err := client.Schedules()
+						if err != nil {
+							return err
+						}
+						return printJSON(schedules)
+					},
+				},
+				{
+					Name:  "get",
+					Usage: "displays a maintenance window",
+					Flags: []cli.Flag{scheduleIDFlag},
+					Action: func(cCtx *cli.Context) error {
+						client, err := newAPIClient(cCtx)
+						if err != nil {
+							return err
+						}
+						schedule, err := client.Schedule(cCtx.String("id"))
+						if err != nil {
+							return err
+						}
+						return printJSON(schedule)
+					},
+				},
+				{
+					Name:  "update",
+					Usage: "replaces a maintenance window, only the end of an active window may be changed",
+					Flags: append([]cli.Flag{scheduleIDFlag}, scheduleFlags...),
+					Action: func(cCtx *cli.Context) error {
+						client, err := newAPIClient(cCtx)
+						if err != nil {
+							return err
+						}
+						schedule, err := client.UpdateSchedule(cCtx.String("id"), scheduleRequest(cCtx))
+						if err != nil {
+							return err
+						}
+						return printJSON(schedule)
+					},
+				},
+				{
+					Name:  "delete",
+					Usage: "deletes a maintenance window which is not active",
+					Flags: []cli.Flag{scheduleIDFlag},
+					Action: func(cCtx *cli.Context) error {
+						client, err := newAPIClient(cCtx)
+						if err != nil {
+							return err
+						}
+						schedule, err := client.DeleteSchedule(cCtx.String("id"))
+						if err != nil {
+							return err
+						}
+						return printJSON(schedule)
+					},
+				},
+			},
+		},
+		{
+			Name:  "reconcile",
+			Usage: "trips and resets circuits with the configured key so the circuits tripped on chain match a state file, watching it for changes",
+			Action: func(cCtx *cli.Context) error {
+				if interval := cCtx.Duration("interval"); interval <= 0 {
+					return fmt.Errorf("interval must be positive, got %s", interval)
+				}
+				ctx, cancel := signal.NotifyContext(cCtx.Context, os.Interrupt, syscall.SIGTERM)
+				defer cancel()
+				cfg, err := config.LoadConfig(cCtx.String("config.path"))
+				if err != nil {
+					return err
+				}
+				logger, err := cfg.ZapLogger(cCtx.Bool("debug.log"))
+				if err != nil {
+					return err
+				}
+				bc, err := breakerclient.NewBreakerClient(ctx, logger, &cfg.Compass)
+				if err != nil {
+					return err
+				}
+				if err = api.ConfigBreakerClient(bc, cCtx.String("key.name")); err != nil {
+					return err
+				}
+				rc := &reconciler{
+					bc:        bc,
+					logger:    logger.Named("breaker.reconcile"),
+					statePath: cCtx.String("state"),
+					groups:    cfg.Groups,
+					planOnly:  cCtx.Bool("plan"),
+				}
+				if url := cCtx.String("notify.url"); url != "" {
+					rc.notifier = api.NewNotifier(url)
+				}
+				if !cCtx.Bool("once") {
+					return rc.watch(ctx, cCtx.Duration("interval"))
+				}
+				plan, err := rc.reconcile(ctx)
+				if err != nil {
+					return err
+				}
+				if rc.planOnly && !plan.Empty() {
+					return fmt.Errorf("circuits differ from %s: %s", rc.statePath, describePlan(plan))
+				}
+				return nil
+			},
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "state",
+					Usage:    "path to a yaml file listing the urls and groups whose circuits should be tripped",
+					Required: true,
+				},
+				&cli.BoolFlag{
+					Name:  "once",
+					Usage: "if present, reconciles a single time and exits instead of watching the state file",
+				},
+				&cli.BoolFlag{
+					Name:  "plan",
+					Usage: "if present, differences are reported without being corrected. combined with --once, exits with an error if circuits differ from the state",
+				},
+				&cli.DurationFlag{
+					Name:  "interval",
+					Usage: "interval at which circuits are compared with the state file, in addition to whenever it changes",
+					Value: time.Minute,
+				},
+				&cli.StringFlag{
+					Name:  "notify.url",
+					Usage: "webhook url, such as a slack incoming webhook, which is notified of every correction",
+				},
+				&cli.StringFlag{
+					Name:  "key.name",
+					Usage: "name of the key to load from the keyring",
+				},
+			},
+		},
 		{
 			Name:  "config",
 			Usage: "configuration management",
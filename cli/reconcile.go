@@ -0,0 +1,181 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/teamscanworks/breaker/api"
+	"github.com/teamscanworks/breaker/breakerclient"
+	"github.com/teamscanworks/breaker/config"
+	"go.uber.org/zap"
+)
+
+const (
+	// time to wait for writes to the state file to settle before reconciling
+	reconcileDebounce = time.Millisecond * 500
+	// maximum time to wait for a correction to be committed
+	reconcileCommitTimeout = time.Second * 30
+)
+
+// Trips and resets circuits so that the circuits tripped on chain match a state file
+type reconciler struct {
+	bc        *breakerclient.BreakerClient
+	logger    *zap.Logger
+	statePath string
+	// circuit groups defined in the configuration file, which the state may reference
+	groups map[string][]string
+	// if true, differences are logged and notified without being corrected
+	planOnly bool
+	// notified of every correction, nil if notifications are disabled
+	notifier *api.Notifier
+}
+
+// Returns the urls which should be tripped according to the state file, expanding groups and glob patterns
+func (rc *reconciler) desiredUrls(ctx context.Context, state *config.CircuitState) ([]string, error) {
+	urls, _, err := api.ResolveGroups(rc.groups, state.UrlList(), state.Groups)
+	if err != nil {
+		return nil, err
+	}
+	registered, err := rc.bc.ListMessages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	urls, err = breakerclient.ExpandPatterns(urls, registered)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(registered))
+	for _, url := range registered {
+		known[url] = true
+	}
+	var unknown []string
+	for _, url := range urls {
+		if !known[url] {
+			unknown = append(unknown, url)
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown module request urls %s", strings.Join(unknown, ", "))
+	}
+	return urls, nil
+}
+
+// Compares the state file with the circuits tripped on chain, correcting any difference in a single
+// transaction unless running in plan only mode. Returns the differences that were found.
+func (rc *reconciler) reconcile(ctx context.Context) (api.CircuitPlan, error) {
+	state, err := config.LoadCircuitState(rc.statePath)
+	if err != nil {
+		return api.CircuitPlan{}, err
+	}
+	urls, err := rc.desiredUrls(ctx, state)
+	if err != nil {
+		return api.CircuitPlan{}, err
+	}
+	disabled, err := rc.bc.ListDisabledCommands(ctx)
+	if err != nil {
+		return api.CircuitPlan{}, fmt.Errorf("failed to list disabled commands %s", err)
+	}
+	plan := api.PlanCircuits(urls, disabled.DisabledList)
+	if plan.Empty() {
+		rc.logger.Debug("circuits match state", zap.Int("tripped", len(plan.Unchanged)))
+		return plan, nil
+	}
+	logger := rc.logger.With(zap.Any("trip", plan.Trip), zap.Any("reset", plan.Reset))
+	if rc.planOnly {
+		logger.Warn("circuits differ from state")
+		rc.notify(ctx, "drift", fmt.Sprintf("circuits differ from %s: %s", rc.statePath, describePlan(plan)), plan)
+		return plan, nil
+	}
+
+	tx, err := rc.bc.UpdateCircuits(ctx, plan.Trip, plan.Reset)
+	if err == nil {
+		var result *breakerclient.TxResult
+		if result, err = rc.bc.WaitForTx(ctx, tx, reconcileCommitTimeout); err == nil && result.Failed() {
+			err = fmt.Errorf("transaction %s failed with code %v (codespace %s): %s", tx, result.Code, result.Codespace, result.RawLog)
+		}
+	}
+	if err != nil {
+		logger.Error("failed to correct circuits", zap.Error(err))
+		rc.notify(ctx, "correction_failed", fmt.Sprintf("failed to correct circuits to match %s (%s): %s", rc.statePath, describePlan(plan), err), plan)
+		return plan, err
+	}
+	logger.Info("corrected circuits", zap.String("tx.hash", tx), zap.String("message", state.Message))
+	rc.notify(ctx, "correction", fmt.Sprintf("corrected circuits to match %s: %s (tx %s)", rc.statePath, describePlan(plan), tx), plan)
+	return plan, nil
+}
+
+// Sends a notification if a notifier is configured, logging any failure
+func (rc *reconciler) notify(ctx context.Context, event string, text string, plan api.CircuitPlan) {
+	if rc.notifier == nil {
+		return
+	}
+	if err := rc.notifier.Notify(ctx, event, text, plan); err != nil {
+		rc.logger.Error("failed to send notification", zap.String("event", event), zap.Error(err))
+	}
+}
+
+// Reconciles whenever the state file changes, and every `interval` to correct changes made on chain, until
+// `ctx` is cancelled. Errors are logged rather than returned, so a bad commit to the state file doesn't stop
+// the reconciler.
+func (rc *reconciler) watch(ctx context.Context, interval time.Duration) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file watcher %s", err)
+	}
+	defer watcher.Close()
+	// the directory is watched, as editors and git replace files rather than writing to them
+	statePath := filepath.Clean(rc.statePath)
+	if err := watcher.Add(filepath.Dir(statePath)); err != nil {
+		return fmt.Errorf("failed to watch state file %s", err)
+	}
+
+	pass := func() {
+		if _, err := rc.reconcile(ctx); err != nil {
+			rc.logger.Error("failed to reconcile circuits", zap.Error(err))
+		}
+	}
+	pass()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	debounce := time.NewTimer(reconcileDebounce)
+	debounce.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(event.Name) == statePath && !event.Has(fsnotify.Chmod) {
+				rc.logger.Debug("state file changed", zap.String("op", event.Op.String()))
+				debounce.Reset(reconcileDebounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			rc.logger.Error("file watcher encountered error", zap.Error(err))
+		case <-debounce.C:
+			pass()
+		case <-ticker.C:
+			pass()
+		}
+	}
+}
+
+// Returns a short summary of the changes in `plan`
+func describePlan(plan api.CircuitPlan) string {
+	var parts []string
+	if len(plan.Trip) > 0 {
+		parts = append(parts, fmt.Sprintf("trip %s", strings.Join(plan.Trip, ", ")))
+	}
+	if len(plan.Reset) > 0 {
+		parts = append(parts, fmt.Sprintf("reset %s", strings.Join(plan.Reset, ", ")))
+	}
+	return strings.Join(parts, "; ")
+}
@@ -1,8 +1,10 @@
 package config
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/99designs/keyring"
 	"github.com/teamscanworks/breaker/api"
@@ -17,11 +19,27 @@ var (
 	ExampleConfig = Configuration{
 		Compass: *compass.GetSimdConfig(),
 		API: API{
-			ListenAddress: "127.0.0.1:6666",
-			Password:      "password123",
-			// empty means no extra identifier is used when validating jwts
-			IdentifierField:              "",
-			TokenValidityDurationSeconds: 86400,
+			ListenAddress:    "127.0.0.1:6666",
+			Password:         "password123",
+			SigningAlgorithm: "HS256",
+			// empty means no extra identifier is used when validating jwts, which
+			// leaves jwt identities anonymous and prevents approvals from being enabled
+			IdentifierField:               "",
+			TokenValidityDurationSeconds:  86400,
+			WaitForCommit:                 false,
+			CommitTimeoutSeconds:          30,
+			HMACToleranceSeconds:          300,
+			IssuedTokenValiditySeconds:    900,
+			IssuedTokenMaxLifetimeSeconds: 86400,
+			Approvals: Approvals{
+				TTLSeconds: 3600,
+			},
+			DriftDetection: DriftDetection{
+				IntervalSeconds: 30,
+			},
+		},
+		Groups: map[string][]string{
+			"bank-freeze": {"/cosmos.bank.v1beta1.MsgSend", "/cosmos.bank.v1beta1.MsgMultiSend"},
 		},
 	}
 )
@@ -30,19 +48,160 @@ var (
 type Configuration struct {
 	Compass compass.ClientConfig `yaml:"compass"`
 	API     API                  `yaml:"api"`
+	// named lists of module request urls, which webhook payloads, alerts and maintenance
+	// windows may reference by name. urls may include glob patterns, ex: "/cosmos.bank.v1beta1.*"
+	Groups map[string][]string `yaml:"groups"`
 }
 
 // configures the breaker api
 type API struct {
 	// address that the api is served on
 	ListenAddress string `yaml:"listen_address"`
-	// password used for encoding/decoding and verifying jwts
+	// password used for encoding/decoding and verifying jwts with a HS256, HS384 or HS512 signature
 	Password string `yaml:"password"`
+	// algorithm used to sign jwts, defaults to HS256. when set to an asymmetric algorithm
+	// (RS256, PS256, ES256, EdDSA, etc..) jwts are signed and verified with the key files below
+	SigningAlgorithm string `yaml:"signing_algorithm"`
+	// path to a PEM encoded private key used to sign jwts, only required when issuing jwts
+	PrivateKeyFile string `yaml:"private_key_file"`
+	// paths to PEM encoded public keys used to verify jwts, if empty the public key of
+	// the private key file is used. multiple keys may be given to allow for key rotation
+	PublicKeyFiles []string `yaml:"public_key_files"`
+	// path to a json file recording issued jwts and revoked jwt ids, allowing
+	// individual tokens to be revoked. can be left empty if not needed
+	TokenStorePath string `yaml:"token_store_path"`
+	// verifies tokens issued by an external openid connect provider, replacing
+	// self issued jwts when enabled
+	OIDC OIDC `yaml:"oidc"`
 	// field used to store additional information in
 	// can be left empty if not needed
 	IdentifierField string `yaml:"identifier_field"`
+	// if true, jwts issued before scopes were enforced, which have no scopes claim, are granted the access they
+	// previously had. intended as a transition period while such tokens are reissued, can't be used with oidc
+	LegacyScopelessTokens bool `yaml:"legacy_scopeless_tokens"`
 	// time in seconds that issued jwt's are valid for
 	TokenValidityDurationSeconds int64 `yaml:"token_validity_duration_seconds"`
+	// if true, webhook calls wait for transactions to be committed and
+	// report the execution result, instead of returning after broadcasting
+	WaitForCommit bool `yaml:"wait_for_commit"`
+	// time in seconds to wait for a transaction to be committed
+	CommitTimeoutSeconds int64 `yaml:"commit_timeout_seconds"`
+	// configures the prometheus alertmanager webhook receiver
+	Alertmanager Alertmanager `yaml:"alertmanager"`
+	// webhook senders which authenticate by signing requests with a shared secret
+	// instead of using a jwt, can be left empty if not needed
+	HMACSenders []HMACSender `yaml:"hmac_senders"`
+	// maximum age in seconds of a hmac signature before the request is rejected
+	HMACToleranceSeconds int64 `yaml:"hmac_tolerance_seconds"`
+	// api keys which may be exchanged for a short lived jwt, can be left empty if not needed
+	APIKeys []APIKey `yaml:"api_keys"`
+	// client certificates which may be exchanged for a short lived jwt, requires tls.client_ca_file
+	ClientCertificates []ClientCertificate `yaml:"client_certificates"`
+	// time in seconds that jwts issued by the api server are valid for
+	IssuedTokenValiditySeconds int64 `yaml:"issued_token_validity_seconds"`
+	// time in seconds after exchanging an api key or client certificate that the issued jwt may be refreshed for
+	IssuedTokenMaxLifetimeSeconds int64 `yaml:"issued_token_max_lifetime_seconds"`
+	// serves the api over tls, can be left empty if not needed
+	TLS TLS `yaml:"tls"`
+	// path to a file recording every circuit operation, can be left empty to disable the audit log
+	AuditLogPath string `yaml:"audit_log_path"`
+	// if true, audit log entries are signed with the keyring key used to sign transactions
+	AuditSignEntries bool `yaml:"audit_sign_entries"`
+	// operations which require approval by a second identity, can be left empty if not needed
+	Approvals Approvals `yaml:"approvals"`
+	// path to a file persisting automatic resets scheduled when tripping circuits with a ttl,
+	// if left empty scheduled resets are lost when the api is restarted
+	ScheduledResetsPath string `yaml:"scheduled_resets_path"`
+	// path to a file persisting maintenance windows, if left empty maintenance windows
+	// are lost when the api is restarted
+	SchedulesPath string `yaml:"schedules_path"`
+	// if true, transactions are simulated to report the estimated gas instead of being broadcast
+	DryRun bool `yaml:"dry_run"`
+	// detects circuits tripped or reset on chain by something other than the api
+	DriftDetection DriftDetection `yaml:"drift_detection"`
+}
+
+// configures detection of circuit changes made on chain by something other than the api
+type DriftDetection struct {
+	// interval in seconds at which the circuits tripped on chain are polled, disabled if zero
+	IntervalSeconds int64 `yaml:"interval_seconds"`
+	// webhook url, such as a slack incoming webhook, notified of every detected change. can be left empty if not needed
+	NotifyURL string `yaml:"notify_url"`
+}
+
+// configures which operations require approval by a second identity before being executed
+type Approvals struct {
+	// if true, resetting circuits requires approval
+	Reset bool `yaml:"reset"`
+	// if true, tripping circuits requires approval
+	Trip bool `yaml:"trip"`
+	// module request urls requiring approval, if empty every url requires approval
+	Urls []string `yaml:"urls"`
+	// time in seconds after which pending proposals expire
+	TTLSeconds int64 `yaml:"ttl_seconds"`
+}
+
+// an api key which may be exchanged for a jwt
+type APIKey struct {
+	// name of the key, used as the identity of issued jwts
+	Name string `yaml:"name"`
+	// secret value of the key
+	Key string `yaml:"key"`
+	// scopes granted to issued jwts, ex: ["circuit:trip"]
+	Scopes []string `yaml:"scopes"`
+}
+
+// a client certificate which may be exchanged for a jwt
+type ClientCertificate struct {
+	// common name of the certificate subject, used as the identity of issued jwts
+	CommonName string `yaml:"common_name"`
+	// scopes granted to issued jwts, ex: ["circuit:trip"]
+	Scopes []string `yaml:"scopes"`
+}
+
+// configures tls for the api server
+type TLS struct {
+	// PEM encoded certificate and key served by the api
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// PEM encoded certificate authority used to verify client certificates
+	ClientCAFile string `yaml:"client_ca_file"`
+}
+
+// a webhook sender authenticated with a hmac-sha256 signature
+type HMACSender struct {
+	// name of the sender, which is included in logs
+	Name string `yaml:"name"`
+	// shared secret used to sign requests
+	Secret string `yaml:"secret"`
+	// scopes granted to the sender, ex: ["circuit:trip"]
+	Scopes []string `yaml:"scopes"`
+}
+
+// configures verification of tokens issued by an openid connect provider
+type OIDC struct {
+	// if true, tokens are verified against the provider's jwks instead of the password or key files
+	Enabled bool `yaml:"enabled"`
+	// expected issuer of tokens, ex: https://accounts.example.com
+	Issuer string `yaml:"issuer"`
+	// audience tokens must be issued for, can be left empty if not needed
+	Audience string `yaml:"audience"`
+	// url the jwks is served from, ex: https://accounts.example.com/.well-known/jwks.json
+	JWKSURL string `yaml:"jwks_url"`
+	// path to a jwks file, used instead of jwks_url
+	JWKSFile string `yaml:"jwks_file"`
+	// claim used as the identity of the caller, defaults to "sub"
+	IdentityClaim string `yaml:"identity_claim"`
+	// claim containing the scopes granted to the caller, defaults to "scopes"
+	ScopesClaim string `yaml:"scopes_claim"`
+	// minimum time in seconds between refreshing the jwks, defaults to 300
+	RefreshIntervalSeconds int64 `yaml:"refresh_interval_seconds"`
+}
+
+// configures how prometheus alertmanager alerts are applied
+type Alertmanager struct {
+	// if true, resolved alerts reset the circuit for the urls of the alert
+	ResetOnResolved bool `yaml:"reset_on_resolved"`
 }
 
 // Saves the example configuration at `path` as a yaml file, you may
@@ -102,12 +261,158 @@ func (c *Configuration) ZapLogger(debug bool) (*zap.Logger, error) {
 	return logger, err
 }
 
-// Returns an instance of the api options struct, which can be set to not broadcast
-// any transactions by setting `dryRun` to true.
+// Returns the JWT used to issue and verify api tokens, signed with the configured signing algorithm.
+// When oidc is enabled the returned JWT only verifies tokens issued by the oidc provider. If a token store
+// is configured, issued tokens are recorded in it and revoked tokens are rejected.
+func (c *Configuration) JWT(ctx context.Context) (*api.JWT, error) {
+	jwt, err := c.newJWT(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if c.API.LegacyScopelessTokens {
+		if c.API.OIDC.Enabled {
+			return nil, fmt.Errorf("api.legacy_scopeless_tokens can't be used with oidc")
+		}
+		jwt.SetLegacyScopelessTokens(true)
+	}
+	if c.API.TokenStorePath != "" {
+		tokens, err := c.TokenStore()
+		if err != nil {
+			return nil, err
+		}
+		jwt.SetTokenStore(tokens)
+	}
+	return jwt, nil
+}
+
+// Opens the token store recording issued and revoked jwts
+func (c *Configuration) TokenStore() (*api.TokenStore, error) {
+	if c.API.TokenStorePath == "" {
+		return nil, fmt.Errorf("api.token_store_path must be set")
+	}
+	return api.OpenTokenStore(c.API.TokenStorePath)
+}
+
+func (c *Configuration) newJWT(ctx context.Context) (*api.JWT, error) {
+	if c.API.OIDC.Enabled {
+		return api.NewJWTFromOIDC(ctx, api.OIDCOpts{
+			Issuer:                 c.API.OIDC.Issuer,
+			Audience:               c.API.OIDC.Audience,
+			JWKSURL:                c.API.OIDC.JWKSURL,
+			JWKSFile:               c.API.OIDC.JWKSFile,
+			IdentityClaim:          c.API.OIDC.IdentityClaim,
+			ScopesClaim:            c.API.OIDC.ScopesClaim,
+			RefreshIntervalSeconds: c.API.OIDC.RefreshIntervalSeconds,
+		})
+	}
+	if c.API.SigningAlgorithm == "" || strings.HasPrefix(c.API.SigningAlgorithm, "HS") {
+		signature := c.API.SigningAlgorithm
+		if signature == "" {
+			signature = "HS256"
+		}
+		return api.NewJWTWithSignature(
+			c.API.Password,
+			c.API.IdentifierField,
+			signature,
+			c.API.TokenValidityDurationSeconds,
+		), nil
+	}
+	return api.NewJWTFromKeyFiles(
+		c.API.SigningAlgorithm,
+		c.API.PrivateKeyFile,
+		c.API.PublicKeyFiles,
+		c.API.IdentifierField,
+		c.API.TokenValidityDurationSeconds,
+	)
+}
+
+// Returns an instance of the api options struct. Setting `api.dry_run` to true simulates
+// transactions instead of broadcasting them.
 func (c *Configuration) ApiOpts() api.ApiOpts {
+	hmacSenders := make([]api.HMACSender, 0, len(c.API.HMACSenders))
+	for _, sender := range c.API.HMACSenders {
+		hmacSenders = append(hmacSenders, api.HMACSender{Name: sender.Name, Secret: sender.Secret, Scopes: sender.Scopes})
+	}
+	apiKeys := make([]api.APIKey, 0, len(c.API.APIKeys))
+	for _, key := range c.API.APIKeys {
+		apiKeys = append(apiKeys, api.APIKey{Name: key.Name, Key: key.Key, Scopes: key.Scopes})
+	}
+	clientCertificates := make([]api.ClientCertificate, 0, len(c.API.ClientCertificates))
+	for _, cert := range c.API.ClientCertificates {
+		clientCertificates = append(clientCertificates, api.ClientCertificate{CommonName: cert.CommonName, Scopes: cert.Scopes})
+	}
+	var approvals *api.ApprovalPolicy
+	if c.API.Approvals.Reset || c.API.Approvals.Trip {
+		approvals = &api.ApprovalPolicy{
+			Reset:      c.API.Approvals.Reset,
+			Trip:       c.API.Approvals.Trip,
+			Urls:       c.API.Approvals.Urls,
+			TTLSeconds: c.API.Approvals.TTLSeconds,
+		}
+	}
 	return api.ApiOpts{
-		ListenAddress:                c.API.ListenAddress,
-		IdentifierField:              c.API.IdentifierField,
-		TokenValidityDurationSeconds: c.API.TokenValidityDurationSeconds,
+		ListenAddress:                 c.API.ListenAddress,
+		IdentifierField:               c.API.IdentifierField,
+		TokenValidityDurationSeconds:  c.API.TokenValidityDurationSeconds,
+		WaitForCommit:                 c.API.WaitForCommit,
+		CommitTimeoutSeconds:          c.API.CommitTimeoutSeconds,
+		AlertmanagerResetOnResolved:   c.API.Alertmanager.ResetOnResolved,
+		HMACSenders:                   hmacSenders,
+		HMACToleranceSeconds:          c.API.HMACToleranceSeconds,
+		APIKeys:                       apiKeys,
+		ClientCertificates:            clientCertificates,
+		IssuedTokenValiditySeconds:    c.API.IssuedTokenValiditySeconds,
+		IssuedTokenMaxLifetimeSeconds: c.API.IssuedTokenMaxLifetimeSeconds,
+		TLSCertFile:                   c.API.TLS.CertFile,
+		TLSKeyFile:                    c.API.TLS.KeyFile,
+		TLSClientCAFile:               c.API.TLS.ClientCAFile,
+		AuditLogPath:                  c.API.AuditLogPath,
+		AuditSignEntries:              c.API.AuditSignEntries,
+		Approvals:                     approvals,
+		ScheduledResetsPath:           c.API.ScheduledResetsPath,
+		SchedulesPath:                 c.API.SchedulesPath,
+		DryRun:                        c.API.DryRun,
+		Groups:                        c.Groups,
+		DriftIntervalSeconds:          c.API.DriftDetection.IntervalSeconds,
+		DriftNotifyURL:                c.API.DriftDetection.NotifyURL,
+	}
+}
+
+// The circuits which should be tripped, as declared by the state file watched by `breaker-cli reconcile`
+type CircuitState struct {
+	// reason recorded when circuits are tripped or reset to match the state
+	Message string `yaml:"message"`
+	// module request urls, or glob patterns, which should be tripped. nil if the file has no `urls` key,
+	// which is distinguished from an explicit empty list.
+	Urls *[]string `yaml:"urls"`
+	// names of circuit groups defined in the configuration file, whose urls should be tripped
+	Groups []string `yaml:"groups"`
+	// must be set to reset every circuit with a state which has neither urls nor groups
+	ResetAll bool `yaml:"reset_all"`
+}
+
+// Returns the urls listed by the state, which are empty if the file has no `urls` key
+func (cs *CircuitState) UrlList() []string {
+	if cs.Urls == nil {
+		return nil
+	}
+	return *cs.Urls
+}
+
+// Loads the circuit state from the yaml file at `path`. A state without an explicit `urls` key, groups or
+// `reset_all: true` is an error rather than an empty state, as it would reset every circuit, and is likely
+// a file which is still being written or had its urls commented out.
+func LoadCircuitState(path string) (*CircuitState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read circuit state %s", err)
+	}
+	var state CircuitState
+	if err := yaml.UnmarshalStrict(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse circuit state %s", err)
+	}
+	if state.Urls == nil && len(state.Groups) == 0 && !state.ResetAll {
+		return nil, fmt.Errorf("circuit state file %s lists no urls or groups, use `urls: []` or `reset_all: true` to reset every circuit", path)
 	}
+	return &state, nil
 }
@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -26,6 +27,10 @@ func TestConfig(t *testing.T) {
 		require.Equal(t, cfg.API.ListenAddress, apiOpts.ListenAddress)
 		require.Equal(t, "", apiOpts.IdentifierField)
 		require.Equal(t, cfg.API.TokenValidityDurationSeconds, apiOpts.TokenValidityDurationSeconds)
+		require.Equal(t, int64(30), apiOpts.CommitTimeoutSeconds)
+		require.False(t, apiOpts.WaitForCommit)
+		require.Equal(t, cfg.Groups, apiOpts.Groups)
+		require.Len(t, apiOpts.Groups["bank-freeze"], 2)
 	})
 	t.Run("osmosis", func(t *testing.T) {
 		t.Cleanup(func() {
@@ -67,3 +72,39 @@ func TestConfig(t *testing.T) {
 
 	})
 }
+
+func TestLoadCircuitState(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "circuits.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("message: incident 42\nurls:\n  - /cosmos.bank.v1beta1.*\ngroups: [dex-freeze]\n"), 0644))
+	state, err := LoadCircuitState(path)
+	require.NoError(t, err)
+	require.Equal(t, "incident 42", state.Message)
+	require.Equal(t, []string{"/cosmos.bank.v1beta1.*"}, state.UrlList())
+	require.Equal(t, []string{"dex-freeze"}, state.Groups)
+
+	require.NoError(t, os.WriteFile(path, []byte("urls: []\n"), 0644))
+	state, err = LoadCircuitState(path)
+	require.NoError(t, err)
+	require.NotNil(t, state.Urls)
+	require.Empty(t, state.UrlList())
+	require.NoError(t, os.WriteFile(path, []byte("reset_all: true\n"), 0644))
+	state, err = LoadCircuitState(path)
+	require.NoError(t, err)
+	require.Empty(t, state.UrlList())
+
+	require.NoError(t, os.WriteFile(path, []byte("\n"), 0644))
+	_, err = LoadCircuitState(path)
+	require.ErrorContains(t, err, "lists no urls or groups")
+	require.NoError(t, os.WriteFile(path, []byte("# urls:\n#   - /cosmos.bank.v1beta1.MsgSend\n"), 0644))
+	_, err = LoadCircuitState(path)
+	require.ErrorContains(t, err, "lists no urls or groups")
+	require.NoError(t, os.WriteFile(path, []byte("message: incident 42\nurls:\n"), 0644))
+	_, err = LoadCircuitState(path)
+	require.ErrorContains(t, err, "lists no urls or groups")
+	require.NoError(t, os.WriteFile(path, []byte("url: /cosmos.bank.v1beta1.MsgSend\n"), 0644))
+	_, err = LoadCircuitState(path)
+	require.ErrorContains(t, err, "failed to parse")
+	_, err = LoadCircuitState(filepath.Join(dir, "missing.yaml"))
+	require.Error(t, err)
+}